@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	IgnoreAnnotation        = "k8s.sentry.io/ignore"
+	IgnoreReasonsAnnotation = "k8s.sentry.io/ignore-reasons"
+	LevelAnnotation         = "k8s.sentry.io/level"
+)
+
+type ownerChainCtxKey struct{}
+
+// An object and its owners, fetched once per event and shared by the lookups
+// of the reporting annotations, the custom tags and the DSN annotations
+type ownerChain struct {
+	objects []metav1.Object
+	// Set if an owner cannot be fetched, the objects end before it
+	err error
+}
+
+// Fetches the owner chain of the object, and returns a context with it: the
+// walks of the chain that start at the object (or at one of its owners) do
+// not fetch the owners again
+func withOwnerChain(ctx context.Context, obj metav1.Object) context.Context {
+	if obj == nil {
+		return ctx
+	}
+	chain := &ownerChain{}
+	chain.err = fetchOwnerChain(ctx, obj, func(owner metav1.Object) bool {
+		chain.objects = append(chain.objects, owner)
+		return true
+	})
+	return context.WithValue(ctx, ownerChainCtxKey{}, chain)
+}
+
+func isSameObject(first metav1.Object, second metav1.Object) bool {
+	if first == second {
+		return true
+	}
+	return first.GetUID() != "" && first.GetUID() == second.GetUID()
+}
+
+// Calls visit for the object and then for each of its owners (following the
+// first owner reference), until visit returns false or the chain ends.
+// Returns an error if an owner cannot be fetched.
+func walkOwnerChain(ctx context.Context, obj metav1.Object, visit func(metav1.Object) bool) error {
+	if chain, ok := ctx.Value(ownerChainCtxKey{}).(*ownerChain); ok && obj != nil {
+		for i, chainObj := range chain.objects {
+			if !isSameObject(chainObj, obj) {
+				continue
+			}
+			for _, owner := range chain.objects[i:] {
+				if !visit(owner) {
+					return nil
+				}
+			}
+			return chain.err
+		}
+	}
+	return fetchOwnerChain(ctx, obj, visit)
+}
+
+// Walks the owner chain, fetching every owner
+func fetchOwnerChain(ctx context.Context, obj metav1.Object, visit func(metav1.Object) bool) error {
+	for obj != nil {
+		if !visit(obj) || len(obj.GetOwnerReferences()) == 0 {
+			return nil
+		}
+
+		owningRef := obj.GetOwnerReferences()[0]
+		owningObject, ok := findObject(ctx, owningRef.Kind, obj.GetNamespace(), owningRef.Name)
+		if !ok {
+			return errors.New("the owning object cannot be found")
+		}
+		obj = owningObject
+	}
+	return nil
+}
+
+// Searches for the given annotations on the object and then on its owners.
+// For every key, the value from the nearest object is returned. If an owner
+// cannot be fetched, the annotations found so far are returned with an error.
+func searchAnnotations(ctx context.Context, obj metav1.Object, keys ...string) (map[string]string, error) {
+	found := make(map[string]string, len(keys))
+
+	err := walkOwnerChain(ctx, obj, func(obj metav1.Object) bool {
+		annotations := obj.GetAnnotations()
+		for _, key := range keys {
+			if _, ok := found[key]; ok {
+				continue
+			}
+			if value, ok := annotations[key]; ok {
+				found[key] = value
+			}
+		}
+		return len(found) < len(keys)
+	})
+	return found, err
+}
+
+var annotationLevels = map[string]sentry.Level{
+	"debug":   sentry.LevelDebug,
+	"info":    sentry.LevelInfo,
+	"warn":    sentry.LevelWarning,
+	"warning": sentry.LevelWarning,
+	"error":   sentry.LevelError,
+	"fatal":   sentry.LevelFatal,
+}
+
+// Per-object reporting settings, read from annotations
+// on the object or on any of its owners
+type reportingOverrides struct {
+	ignored bool
+	level   sentry.Level
+}
+
+func getReportingOverrides(ctx context.Context, object metav1.Object, reason string) *reportingOverrides {
+	overrides := &reportingOverrides{}
+	if object == nil {
+		return overrides
+	}
+	logger := zerolog.Ctx(ctx)
+
+	annotations, err := searchAnnotations(ctx, object, IgnoreAnnotation, IgnoreReasonsAnnotation, LevelAnnotation)
+	if err != nil {
+		logger.Debug().Msgf("Cannot read all reporting annotations: %v", err)
+	}
+
+	if isTruthy(annotations[IgnoreAnnotation]) {
+		overrides.ignored = true
+	}
+
+	if ignoreReasons, ok := annotations[IgnoreReasonsAnnotation]; ok && reason != "" {
+		if containsFold(strings.Split(ignoreReasons, ","), reason) {
+			overrides.ignored = true
+		}
+	}
+
+	if rawLevel, ok := annotations[LevelAnnotation]; ok {
+		level, found := annotationLevels[strings.ToLower(strings.TrimSpace(rawLevel))]
+		if found {
+			overrides.level = level
+		} else {
+			logger.Warn().Msgf("Invalid value of the %s annotation: %q", LevelAnnotation, rawLevel)
+		}
+	}
+
+	return overrides
+}
+
+func (o *reportingOverrides) apply(sentryEvent *sentry.Event) {
+	if o.level != "" {
+		sentryEvent.Level = o.level
+	}
+}
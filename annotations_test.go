@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetReportingOverrides(t *testing.T) {
+	replicasetObj := &v1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestReportingOverridesReplicaset",
+			Namespace: "TestReportingOverridesNamespace",
+			Annotations: map[string]string{
+				IgnoreReasonsAnnotation: "BackOff, Unhealthy",
+				LevelAnnotation:         "warning",
+			},
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestReportingOverridesPod",
+			Namespace: "TestReportingOverridesNamespace",
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "ReplicaSet",
+					Name: "TestReportingOverridesReplicaset",
+				},
+			},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(replicasetObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	// The ignored reasons and the level are inherited from the replicaset
+	overrides := getReportingOverrides(ctx, podObj, "unhealthy")
+	if !overrides.ignored {
+		t.Errorf("The event with an ignored reason should be ignored")
+	}
+	overrides = getReportingOverrides(ctx, podObj, "OOMKilled")
+	if overrides.ignored {
+		t.Errorf("The event with a non-ignored reason should not be ignored")
+	}
+	sentryEvent := &sentry.Event{Level: sentry.LevelError}
+	overrides.apply(sentryEvent)
+	if sentryEvent.Level != sentry.LevelWarning {
+		t.Errorf("Level expected: %s, actual: %s", sentry.LevelWarning, sentryEvent.Level)
+	}
+
+	// Annotations on the object itself take precedence over its owners
+	podObj.Annotations = map[string]string{
+		IgnoreAnnotation: "true",
+		LevelAnnotation:  "invalid",
+	}
+	overrides = getReportingOverrides(ctx, podObj, "OOMKilled")
+	if !overrides.ignored {
+		t.Errorf("The ignored pod should be ignored")
+	}
+	if overrides.level != "" {
+		t.Errorf("An invalid level should not be applied, got: %s", overrides.level)
+	}
+}
+
+func TestWithOwnerChain(t *testing.T) {
+	deploymentObj := &v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestOwnerChainDeployment",
+			Namespace:   "TestOwnerChainNamespace",
+			UID:         "deployment-uid",
+			Annotations: map[string]string{LevelAnnotation: "warning"},
+		},
+	}
+	replicasetObj := &v1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestOwnerChainReplicaset",
+			Namespace: "TestOwnerChainNamespace",
+			UID:       "replicaset-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "Deployment", Name: "TestOwnerChainDeployment"},
+			},
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestOwnerChainPod",
+			Namespace: "TestOwnerChainNamespace",
+			UID:       "pod-uid",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "ReplicaSet", Name: "TestOwnerChainReplicaset"},
+			},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(deploymentObj, replicasetObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	ctx = withOwnerChain(ctx, podObj)
+	fetched := len(fakeClientset.Actions())
+	if fetched != 2 {
+		t.Fatalf("Owners fetched expected: 2, actual: %d", fetched)
+	}
+
+	// The walks of the chain, from the object or from an owner, reuse it
+	overrides := getReportingOverrides(ctx, podObj, "BackOff")
+	if overrides.level != sentry.LevelWarning {
+		t.Errorf("Level expected: %s, actual: %s", sentry.LevelWarning, overrides.level)
+	}
+	collectCustomTags(ctx, podObj)
+	var visited []string
+	err := walkOwnerChain(ctx, replicasetObj, func(owner metav1.Object) bool {
+		visited = append(visited, owner.GetName())
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 2 || visited[1] != "TestOwnerChainDeployment" {
+		t.Errorf("Unexpected owners of the replicaset: %v", visited)
+	}
+	if actions := len(fakeClientset.Actions()); actions != fetched {
+		t.Errorf("The owners should not be fetched again, API calls: %d", actions-fetched)
+	}
+}
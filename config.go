@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	globalLogger "github.com/rs/zerolog/log"
+	"sigs.k8s.io/yaml"
+)
+
+// Agent settings that cannot be easily expressed with environment variables
+// (e.g. lists of rules) are read from an optional YAML configuration file
+type AgentConfig struct {
+	Sampling    []*SamplingRule `json:"sampling,omitempty"`
+	MuteWindows []*MuteWindow   `json:"muteWindows,omitempty"`
+	TagMappings TagMappings     `json:"tagMappings,omitempty"`
+	Routing     []*RoutingRule  `json:"routing,omitempty"`
+	Crons       *CronsConfig    `json:"crons,omitempty"`
+
+	DsnOverrides []*DsnClientOverride   `json:"dsnOverrides,omitempty"`
+	PlatformDsns []*PlatformDestination `json:"platformDsns,omitempty"`
+}
+
+var agentConfig = &AgentConfig{}
+
+func parseAgentConfig(data []byte) (*AgentConfig, error) {
+	config := &AgentConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func loadAgentConfig() error {
+	configPath := strings.TrimSpace(os.Getenv("SENTRY_K8S_CONFIG_PATH"))
+	if configPath == "" {
+		globalLogger.Debug().Msg("No agent configuration file specified")
+		return nil
+	}
+
+	globalLogger.Info().Msgf("Reading agent configuration from: %s", configPath)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("cannot read the agent configuration file: %v", err)
+	}
+
+	config, err := parseAgentConfig(data)
+	if err != nil {
+		return fmt.Errorf("cannot parse the agent configuration file: %v", err)
+	}
+	agentConfig = config
+	return nil
+}
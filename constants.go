@@ -6,4 +6,6 @@ const (
 	KindCronjob    string = "CronJob"
 	KindReplicaset string = "ReplicaSet"
 	KindDeployment string = "Deployment"
+	KindNamespace  string = "Namespace"
+	KindNode       string = "Node"
 )
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
 type clientsetCtxKey struct{}
+type dynamicClientCtxKey struct{}
 
 // Note: we do not use kubernetes.Clientset type directly, to enable mocking via "k8s.io/client-go/kubernetes/fake"
 // package. A type alias is introduced to avoid confusion: "kubernetes.Interface" is actually a clientset.Interface, and
@@ -29,3 +32,37 @@ func getClientsetFromContext(ctx context.Context) (ClientsetInterface, error) {
 		return nil, fmt.Errorf("cannot convert clientset value from context")
 	}
 }
+
+// The dynamic client reads the resources that are not part of the clientset, e.g. Argo CronWorkflows
+func setDynamicClientOnContext(ctx context.Context, client dynamic.Interface) context.Context {
+	return context.WithValue(ctx, dynamicClientCtxKey{}, client)
+}
+
+func getDynamicClientFromContext(ctx context.Context) (dynamic.Interface, error) {
+	val := ctx.Value(dynamicClientCtxKey{})
+	if val == nil {
+		return nil, fmt.Errorf("no dynamic client present on context")
+	}
+	if client, ok := val.(dynamic.Interface); ok {
+		return client, nil
+	} else {
+		return nil, fmt.Errorf("cannot convert dynamic client value from context")
+	}
+}
+
+// A context that keeps the values of its parent, but is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (c detachedContext) Done() <-chan struct{}             { return nil }
+func (c detachedContext) Err() error                        { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// Returns a context with the same values (clientset, hub, logger) that is not
+// cancelled with the parent, so that the processing of an event that has
+// already started is completed when the agent shuts down
+func detachContext(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
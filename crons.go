@@ -3,12 +3,29 @@ package main
 import (
 	"context"
 	"errors"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/getsentry/sentry-go"
-	"github.com/rs/zerolog"
+	"github.com/robfig/cron/v3"
 	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// The annotation set on the jobs created with "kubectl create job --from=cronjob/..."
+const manualJobAnnotation = "cronjob.kubernetes.io/instantiate"
+
+// What is done with the runs of jobs created by hand from a CronJob
+const (
+	ManualJobsIgnore   = "ignore"
+	ManualJobsSeparate = "separate"
+	ManualJobsInclude  = "include"
+)
+
+// Suffix of the slug of the monitor of manual runs
+const manualMonitorSlugSuffix = "-manual"
+
 type EventHandlerType string
 
 const (
@@ -17,10 +34,29 @@ const (
 	EventHandlerDelete EventHandlerType = "DELETE"
 )
 
-// Captures sentry crons checkin event if appropriate
-// by checking the job status to determine if the job just created pod (job starting)
-// or if the job exited
-func runSentryCronsCheckin(ctx context.Context, job *batchv1.Job, eventHandlerType EventHandlerType) error {
+func isManualJob(job *batchv1.Job) bool {
+	return job.Annotations[manualJobAnnotation] == "manual"
+}
+
+// Returns what is done with manual runs: SENTRY_K8S_MONITOR_MANUAL_JOBS
+// is "ignore" (the default), "separate" or "include"
+func getManualJobsMode() string {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("SENTRY_K8S_MONITOR_MANUAL_JOBS")))
+	switch mode {
+	case ManualJobsSeparate, ManualJobsInclude:
+		return mode
+	default:
+		return ManualJobsIgnore
+	}
+}
+
+type cronsDestinationsCtxKey struct{}
+
+// Runs the check-in function with a clone of the hub, bound to the first of
+// the clients that the object's events are sent with. The check-ins and the
+// messages captured with captureCronsCheckIn and captureCronsMessage are
+// also sent with the other clients, e.g. for a list of DSNs in the annotation.
+func withCronsHub(ctx context.Context, object metav1.Object, checkin func(ctx context.Context)) error {
 	hub := sentry.GetHubFromContext(ctx)
 	if hub == nil {
 		return errors.New("cannot get hub from context")
@@ -28,121 +64,104 @@ func runSentryCronsCheckin(ctx context.Context, job *batchv1.Job, eventHandlerTy
 
 	// To avoid concurrency issue
 	hub = hub.Clone()
-
-	// Try to find the cronJob name that owns the job
-	// in order to get the crons monitor data
-	if len(job.OwnerReferences) == 0 {
-		return errors.New("job does not have cronjob reference")
-	}
-	cronjobRef := job.OwnerReferences[0]
-	if cronjobRef.Controller == nil || !*cronjobRef.Controller || cronjobRef.Kind != KindCronjob {
-		return errors.New("job does not have cronjob reference")
-	}
-	cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(cronjobRef.Name)
-	if !ok {
-		return errors.New("cannot find cronJob data")
-	}
-
 	hub.WithScope(func(scope *sentry.Scope) {
-		// If DSN annotation provided, we bind a new client with that DSN
-		client, ok := dsnClientMapping.GetClientFromObject(ctx, &job.ObjectMeta, hub.Client().Options())
-		if ok {
-			hub.BindClient(client)
+		// DSN annotations take precedence over the routing rules
+		route := findRoutingRule(ctx, object.GetNamespace())
+		route.applyToScope(scope)
+		clients := getDestinationClients(ctx, hub, route, object)
+		hub.BindClient(clients[0])
+
+		destinations := []*sentry.Client{}
+		seenDsns := map[string]bool{clients[0].Options().Dsn: true}
+		for _, client := range clients[1:] {
+			if dsn := client.Options().Dsn; !seenDsns[dsn] {
+				seenDsns[dsn] = true
+				destinations = append(destinations, client)
+			}
 		}
 
 		// Pass clone hub down with context
-		ctx = sentry.SetHubOnContext(ctx, hub)
-		// The job just begun so check in to start
-		if job.Status.Active == 0 && job.Status.Succeeded == 0 && job.Status.Failed == 0 {
-			// Add the job to the cronJob informer data
-			err := checkinJobStarting(ctx, job, cronsMonitorData)
-			if err != nil {
-				return
-			}
-		} else if job.Status.Active > 0 {
-			return
-		} else if job.Status.Failed > 0 || job.Status.Succeeded > 0 {
-			err := checkinJobEnding(ctx, job, cronsMonitorData)
-			if err != nil {
-				return
-			}
-			return // Finished
-		}
+		ctx := sentry.SetHubOnContext(ctx, hub)
+		checkin(context.WithValue(ctx, cronsDestinationsCtxKey{}, destinations))
 	})
-
 	return nil
 }
 
-// Sends the checkin event to sentry crons for when a job starts
-func checkinJobStarting(ctx context.Context, job *batchv1.Job, cronsMonitorData *CronsMonitorData) error {
-	logger := zerolog.Ctx(ctx)
+// Returns the clients, other than the one of the hub, that the
+// check-ins are sent with
+func getCronsDestinations(ctx context.Context) []*sentry.Client {
+	destinations, _ := ctx.Value(cronsDestinationsCtxKey{}).([]*sentry.Client)
+	return destinations
+}
 
+// Captures the check-in with the hub of the context, and with every other
+// destination of the run. All the destinations get the same check-in ID,
+// so the ID that is stored closes the run in every project.
+func captureCronsCheckIn(ctx context.Context, checkIn *sentry.CheckIn, monitorConfig *sentry.MonitorConfig) *sentry.EventID {
 	hub := sentry.GetHubFromContext(ctx)
 	if hub == nil {
-		return errors.New("cannot get hub from context")
+		return nil
 	}
-
-	// Check if job already added to jobData slice
-	_, ok := cronsMonitorData.JobDatas[job.Name]
-	if ok {
+	checkinID := hub.CaptureCheckIn(checkIn, monitorConfig)
+	if checkinID == nil {
 		return nil
 	}
-	logger.Debug().Msgf("Checking in at start of job: %s\n", job.Name)
-
-	// All containers running in the pod
-	checkinID := hub.CaptureCheckIn(
-		&sentry.CheckIn{
-			MonitorSlug: cronsMonitorData.MonitorSlug,
-			Status:      sentry.CheckInStatusInProgress,
-		},
-		cronsMonitorData.monitorConfig,
-	)
-	err := cronsMonitorData.addJob(job, *checkinID)
-	if err != nil {
-		return err
+	for _, client := range getCronsDestinations(ctx) {
+		destinationCheckIn := *checkIn
+		destinationCheckIn.ID = *checkinID
+		client.CaptureCheckIn(&destinationCheckIn, monitorConfig, hub.Scope())
 	}
-
-	return nil
+	return checkinID
 }
 
-// Sends the checkin event to sentry crons for when a job ends
-func checkinJobEnding(ctx context.Context, job *batchv1.Job, cronsMonitorData *CronsMonitorData) error {
+// Captures the message with the hub of the context, and with every
+// other destination of the run
+func captureCronsMessage(ctx context.Context, scope *sentry.Scope, message string) {
 	hub := sentry.GetHubFromContext(ctx)
 	if hub == nil {
-		return errors.New("cannot get hub from context")
+		return
 	}
+	hub.CaptureMessage(message)
+	for _, client := range getCronsDestinations(ctx) {
+		client.CaptureMessage(message, nil, scope)
+	}
+}
 
-	logger := zerolog.Ctx(ctx)
-
-	// Check desired number of pods have succeeded
-	var jobStatus sentry.CheckInStatus
-
-	if job.Status.Conditions == nil {
-		return nil
-	} else {
-		if job.Status.Conditions[0].Type == "Complete" {
-			jobStatus = sentry.CheckInStatusOK
-		} else if job.Status.Conditions[0].Type == "Failed" {
-			jobStatus = sentry.CheckInStatusError
-		} else {
-			return nil
-		}
+// Reports what the concurrency policy of the CronJob did to a run, as a
+// warning linked to the run, because Sentry only sees a missed or failed check-in
+func reportConcurrencyOutcome(ctx context.Context, job *batchv1.Job, cronsMonitorData *CronsMonitorData, checkinID sentry.EventID, message string) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		return
 	}
+	monitorSlug, _ := cronsMonitorData.getMonitorForJob(job)
+	_, concurrencyPolicy := cronsMonitorData.getSchedule()
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelWarning)
+		scope.SetContext("Monitor", sentry.Context{
+			"Slug":        monitorSlug,
+			"Check-in ID": string(checkinID),
+		})
+		setTagIfNotEmpty(scope, "cronjob_name", getCronJobName(job))
+		setTagIfNotEmpty(scope, "job_name", job.Name)
+		scope.SetFingerprint([]string{"concurrency-policy", string(concurrencyPolicy), monitorSlug})
+		captureCronsMessage(ctx, scope, message)
+	})
+}
 
-	// Get job data to retrieve the checkin ID
-	jobData, ok := cronsMonitorData.JobDatas[job.Name]
-	if !ok {
-		return nil
+// Returns the name of the CronJob that created the job
+func getCronJobName(job *batchv1.Job) string {
+	if ref := metav1.GetControllerOf(job); ref != nil && ref.Kind == KindCronjob {
+		return ref.Name
 	}
+	return ""
+}
 
-	logger.Trace().Msgf("checking in at end of job: %s\n", job.Name)
-	hub.CaptureCheckIn(
-		&sentry.CheckIn{
-			ID:          jobData.getCheckinID(),
-			MonitorSlug: cronsMonitorData.MonitorSlug,
-			Status:      jobStatus,
-		},
-		cronsMonitorData.monitorConfig,
-	)
-	return nil
+// Counts the scheduled times in (start, end], at most 100 like the CronJob controller
+func countScheduledTimes(schedule cron.Schedule, start time.Time, end time.Time) int {
+	count := 0
+	for next := schedule.Next(start); !next.IsZero() && !next.After(end) && count < 100; next = schedule.Next(next) {
+		count++
+	}
+	return count
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Job conditions that were added after the API version the agent is built
+// with: the job is being terminated and is about to fail or succeed
+const (
+	jobConditionFailureTarget      batchv1.JobConditionType = "FailureTarget"
+	jobConditionSuccessCriteriaMet batchv1.JobConditionType = "SuccessCriteriaMet"
+)
+
+// The default spec.backoffLimit of jobs
+const defaultJobBackoffLimit int32 = 6
+
+// The outcome of a finished run of a CronJob
+type jobRunResult struct {
+	Status  sentry.CheckInStatus
+	Reason  string
+	Message string
+	// The number of failed pods (each one is retried until the backoff limit)
+	Retries int32
+	// The indexes that did not complete, for jobs in the Indexed completion mode
+	FailedIndexes string
+	Duration      time.Duration
+}
+
+// Returns the outcome of the job, or nil while the job is still running
+// (or retrying). The conditions of the job are preferred; without them
+// the outcome is computed from the completions and the backoff limit.
+func getJobRunResult(job *batchv1.Job, requiredCompletions int32) *jobRunResult {
+	// A failure takes precedence, a job is not retried after it is marked as failed
+	var failure, success *batchv1.JobCondition
+	for i, condition := range job.Status.Conditions {
+		if condition.Status != v1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobFailed, jobConditionFailureTarget:
+			if failure == nil {
+				failure = &job.Status.Conditions[i]
+			}
+		case batchv1.JobComplete, jobConditionSuccessCriteriaMet:
+			if success == nil {
+				success = &job.Status.Conditions[i]
+			}
+		}
+	}
+
+	var result *jobRunResult
+	var finishedAt time.Time
+	if failure != nil {
+		result = &jobRunResult{Status: sentry.CheckInStatusError, Reason: failure.Reason, Message: failure.Message}
+		finishedAt = failure.LastTransitionTime.Time
+	} else if success != nil {
+		result = &jobRunResult{Status: sentry.CheckInStatusOK, Reason: success.Reason, Message: success.Message}
+		finishedAt = success.LastTransitionTime.Time
+	}
+
+	completions := requiredCompletions
+	if job.Spec.Completions != nil {
+		completions = *job.Spec.Completions
+	}
+	indexed := job.Spec.CompletionMode != nil && *job.Spec.CompletionMode == batchv1.IndexedCompletion
+
+	if result == nil {
+		backoffLimit := defaultJobBackoffLimit
+		if job.Spec.BackoffLimit != nil {
+			backoffLimit = *job.Spec.BackoffLimit
+		}
+		switch {
+		case job.Status.Failed > backoffLimit:
+			result = &jobRunResult{Status: sentry.CheckInStatusError, Reason: "BackoffLimitExceeded"}
+		case indexed && int32(len(parseCompletedIndexes(job.Status.CompletedIndexes))) >= completions:
+			result = &jobRunResult{Status: sentry.CheckInStatusOK}
+		case !indexed && job.Spec.Completions != nil && job.Status.Succeeded >= completions:
+			result = &jobRunResult{Status: sentry.CheckInStatusOK}
+		case !indexed && job.Spec.Completions == nil && job.Status.Succeeded > 0 && job.Status.Active == 0:
+			// Without completions (a work queue), the job is done once a pod
+			// succeeded and all the pods terminated
+			result = &jobRunResult{Status: sentry.CheckInStatusOK}
+		default:
+			return nil
+		}
+	}
+
+	result.Retries = job.Status.Failed
+	if indexed && result.Status == sentry.CheckInStatusError {
+		result.FailedIndexes = getFailedIndexes(job.Status.CompletedIndexes, completions)
+	}
+	if job.Status.CompletionTime != nil {
+		finishedAt = job.Status.CompletionTime.Time
+	}
+	if job.Status.StartTime != nil && !finishedAt.IsZero() && finishedAt.After(job.Status.StartTime.Time) {
+		result.Duration = finishedAt.Sub(job.Status.StartTime.Time)
+	}
+	return result
+}
+
+// Parses the completed indexes of an indexed job, e.g. "1,3-5,7"
+func parseCompletedIndexes(completedIndexes string) map[int]bool {
+	indexes := make(map[int]bool)
+	for _, interval := range strings.Split(completedIndexes, ",") {
+		interval = strings.TrimSpace(interval)
+		if interval == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(interval, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil {
+				continue
+			}
+		}
+		for index := start; index <= end; index++ {
+			indexes[index] = true
+		}
+	}
+	return indexes
+}
+
+// Returns the indexes of an indexed job that did not complete,
+// in the same format as the completed indexes
+func getFailedIndexes(completedIndexes string, completions int32) string {
+	completed := parseCompletedIndexes(completedIndexes)
+	var intervals []string
+	for index := 0; index < int(completions); index++ {
+		if completed[index] {
+			continue
+		}
+		end := index
+		for end+1 < int(completions) && !completed[end+1] {
+			end++
+		}
+		if end == index {
+			intervals = append(intervals, strconv.Itoa(index))
+		} else {
+			intervals = append(intervals, fmt.Sprintf("%d-%d", index, end))
+		}
+		index = end
+	}
+	return strings.Join(intervals, ",")
+}
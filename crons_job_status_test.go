@@ -0,0 +1,201 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetJobRunResult(t *testing.T) {
+	int32Ptr := func(value int32) *int32 { return &value }
+	indexed := batchv1.IndexedCompletion
+	startTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	endTime := metav1.NewTime(startTime.Add(90 * time.Second))
+	condition := func(conditionType batchv1.JobConditionType, reason string) batchv1.JobCondition {
+		return batchv1.JobCondition{Type: conditionType, Status: v1.ConditionTrue, Reason: reason, LastTransitionTime: endTime}
+	}
+
+	testCases := []struct {
+		name     string
+		spec     batchv1.JobSpec
+		status   batchv1.JobStatus
+		expected *jobRunResult
+	}{
+		{
+			name:     "single pod completed",
+			status:   batchv1.JobStatus{Succeeded: 1, StartTime: &startTime, CompletionTime: &endTime, Conditions: []batchv1.JobCondition{condition(batchv1.JobComplete, "")}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK, Duration: 90 * time.Second},
+		},
+		{
+			name: "complete after another condition",
+			status: batchv1.JobStatus{Succeeded: 1, Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobSuspended, Status: v1.ConditionFalse},
+				condition(batchv1.JobComplete, ""),
+			}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK},
+		},
+		{
+			name:     "backoff limit exceeded",
+			spec:     batchv1.JobSpec{BackoffLimit: int32Ptr(2)},
+			status:   batchv1.JobStatus{Failed: 3, StartTime: &startTime, Conditions: []batchv1.JobCondition{condition(batchv1.JobFailed, "BackoffLimitExceeded")}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusError, Reason: "BackoffLimitExceeded", Retries: 3, Duration: 90 * time.Second},
+		},
+		{
+			name:     "retrying",
+			spec:     batchv1.JobSpec{BackoffLimit: int32Ptr(2)},
+			status:   batchv1.JobStatus{Failed: 2, Active: 1},
+			expected: nil,
+		},
+		{
+			name:     "retries exhausted without a condition",
+			spec:     batchv1.JobSpec{BackoffLimit: int32Ptr(2)},
+			status:   batchv1.JobStatus{Failed: 3},
+			expected: &jobRunResult{Status: sentry.CheckInStatusError, Reason: "BackoffLimitExceeded", Retries: 3},
+		},
+		{
+			name:     "failure target while pods terminate",
+			status:   batchv1.JobStatus{Active: 1, Failed: 1, Conditions: []batchv1.JobCondition{condition(jobConditionFailureTarget, "PodFailurePolicy")}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusError, Reason: "PodFailurePolicy", Retries: 1},
+		},
+		{
+			name:     "success criteria met",
+			spec:     batchv1.JobSpec{Completions: int32Ptr(3), CompletionMode: &indexed},
+			status:   batchv1.JobStatus{Active: 2, Succeeded: 1, CompletedIndexes: "0", Conditions: []batchv1.JobCondition{condition(jobConditionSuccessCriteriaMet, "SuccessPolicy")}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK, Reason: "SuccessPolicy"},
+		},
+		{
+			name:     "parallel job with pending completions",
+			spec:     batchv1.JobSpec{Completions: int32Ptr(3), Parallelism: int32Ptr(2)},
+			status:   batchv1.JobStatus{Active: 1, Succeeded: 2},
+			expected: nil,
+		},
+		{
+			name:     "parallel job with all completions",
+			spec:     batchv1.JobSpec{Completions: int32Ptr(3), Parallelism: int32Ptr(2)},
+			status:   batchv1.JobStatus{Succeeded: 3, Failed: 1},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK, Retries: 1},
+		},
+		{
+			name:     "work queue with running pods",
+			spec:     batchv1.JobSpec{Parallelism: int32Ptr(3)},
+			status:   batchv1.JobStatus{Active: 2, Succeeded: 1},
+			expected: nil,
+		},
+		{
+			name:     "work queue finished",
+			spec:     batchv1.JobSpec{Parallelism: int32Ptr(3)},
+			status:   batchv1.JobStatus{Succeeded: 3},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK},
+		},
+		{
+			name:     "indexed job with failed indexes",
+			spec:     batchv1.JobSpec{Completions: int32Ptr(6), CompletionMode: &indexed},
+			status:   batchv1.JobStatus{Succeeded: 3, Failed: 7, CompletedIndexes: "0,2-3", Conditions: []batchv1.JobCondition{condition(batchv1.JobFailed, "BackoffLimitExceeded")}},
+			expected: &jobRunResult{Status: sentry.CheckInStatusError, Reason: "BackoffLimitExceeded", Retries: 7, FailedIndexes: "1,4-5"},
+		},
+		{
+			name:     "indexed job with all indexes",
+			spec:     batchv1.JobSpec{Completions: int32Ptr(4), CompletionMode: &indexed},
+			status:   batchv1.JobStatus{Succeeded: 4, CompletedIndexes: "0-3"},
+			expected: &jobRunResult{Status: sentry.CheckInStatusOK},
+		},
+	}
+
+	for _, testCase := range testCases {
+		job := &batchv1.Job{Spec: testCase.spec, Status: testCase.status}
+		result := getJobRunResult(job, 1)
+		if testCase.expected == nil {
+			if result != nil {
+				t.Errorf("%s: the job should still be running, result: %#v", testCase.name, result)
+			}
+			continue
+		}
+		if result == nil {
+			t.Errorf("%s: the job should be finished", testCase.name)
+			continue
+		}
+		expected := *testCase.expected
+		expected.Message = result.Message
+		if *result != expected {
+			t.Errorf("%s: result expected: %#v, actual: %#v", testCase.name, expected, *result)
+		}
+	}
+}
+
+func TestCheckinJobEndingWithRetries(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-1")
+	job.Status.Active = 1
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	// A failed pod is retried, the run is still in progress
+	job.Status = batchv1.JobStatus{Active: 1, Failed: 1}
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if events := transport.Events(); len(events) != 1 {
+		t.Fatalf("Check-ins expected: 1, actual: %d", len(events))
+	}
+
+	startTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	completionTime := metav1.NewTime(startTime.Add(2 * time.Minute))
+	job.Status = batchv1.JobStatus{Succeeded: 1, Failed: 1, StartTime: &startTime, CompletionTime: &completionTime, Conditions: []batchv1.JobCondition{
+		{Type: batchv1.JobSuspended, Status: v1.ConditionFalse},
+		{Type: batchv1.JobComplete, Status: v1.ConditionTrue},
+	}}
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+	events := transport.Events()
+	if len(events) != 2 || events[1].CheckIn.Status != sentry.CheckInStatusOK || events[1].CheckIn.ID != events[0].CheckIn.ID {
+		t.Fatalf("A successful ending check-in expected: %#v", events)
+	}
+	if events[1].CheckIn.Duration != 2*time.Minute {
+		t.Errorf("Check-in duration expected: 2m, actual: %s", events[1].CheckIn.Duration)
+	}
+}
+
+func TestCheckinFailedJobReportsError(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-1")
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+
+	startTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	job.Status = batchv1.JobStatus{Failed: 3, StartTime: &startTime, Conditions: []batchv1.JobCondition{{
+		Type:               batchv1.JobFailed,
+		Status:             v1.ConditionTrue,
+		Reason:             "BackoffLimitExceeded",
+		Message:            "Job has reached the specified backoff limit",
+		LastTransitionTime: metav1.NewTime(startTime.Add(4 * time.Minute)),
+	}}}
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+
+	events := transport.Events()
+	if len(events) != 3 || events[1].CheckIn.Status != sentry.CheckInStatusError {
+		t.Fatalf("Events expected: 2 check-ins and the error of the failed run, actual: %#v", events)
+	}
+	event := events[2]
+	if event.Level != sentry.LevelError || event.Message != "CronJob run backup-1 failed: BackoffLimitExceeded (Job has reached the specified backoff limit)" {
+		t.Errorf("An error about the failed run expected: %#v", event)
+	}
+	monitorContext := event.Contexts["Monitor"]
+	if monitorContext["Slug"] != "backup" || monitorContext["Check-in ID"] != string(events[0].CheckIn.ID) {
+		t.Errorf("The error should be linked to the monitor: %#v", monitorContext)
+	}
+	runContext := event.Contexts["Run"]
+	if runContext["Reason"] != "BackoffLimitExceeded" || runContext["Retries"] != int32(3) || runContext["Duration"] != "4m0s" {
+		t.Errorf("The outcome of the run expected: %#v", runContext)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+)
+
+// How often the CronJobs are checked for runs that did not start
+const missedRunsCheckInterval = 30 * time.Second
+
+// Without startingDeadlineSeconds Kubernetes still starts a late run, it is
+// reported as late after this margin (the default check-in margin of Sentry)
+const defaultLateRunMargin = time.Minute
+
+// Whether the run did not start before startingDeadlineSeconds (and will not
+// start), or is late without a deadline
+const (
+	MissedRunMissed = "missed"
+	MissedRunLate   = "late"
+)
+
+// A scheduled run of a CronJob that did not start on time
+type missedRun struct {
+	Kind          string
+	ScheduledTime time.Time
+	Margin        time.Duration
+	// The number of scheduled times that passed without a run
+	Count int
+	// The reason and the message of the latest warning event of the CronJob
+	Reason  string
+	Message string
+}
+
+// Checks the CronJobs for runs that did not start on time until the stop channel is closed
+func watchMissedCronJobRuns(ctx context.Context, stopChan <-chan struct{}, cronjobInformer cache.SharedIndexInformer) {
+	defer reportPanicAndCrash()
+	ticker := time.NewTicker(missedRunsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case now := <-ticker.C:
+			checkMissedCronJobRuns(ctx, cronjobInformer.GetStore().List(), now)
+		}
+	}
+}
+
+// Reports the scheduled runs of the monitored CronJobs that did not start
+// on time, with the reason found in the events of the CronJob
+func checkMissedCronJobRuns(ctx context.Context, cronjobs []interface{}, now time.Time) {
+	logger := zerolog.Ctx(ctx)
+	for _, obj := range cronjobs {
+		cronjob, ok := obj.(*batchv1.CronJob)
+		if !ok || !shards.ownsObject(cronjob.Namespace, cronjob.UID) {
+			continue
+		}
+		cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(cronsMonitorKey(cronjob.Namespace, cronjob.Name))
+		if !ok {
+			continue
+		}
+		run := getMissedRun(cronjob, cronsMonitorData, now)
+		if run == nil || !cronsMonitorData.markMissedRun(run.ScheduledTime) {
+			continue
+		}
+		run.Reason, run.Message = getCronJobWarning(ctx, cronjob, run.ScheduledTime)
+		logger.Info().Msgf("The scheduled run of CronJob %s/%s at %s is %s: %s",
+			cronjob.Namespace, cronjob.Name, run.ScheduledTime.Format(time.RFC3339), run.Kind, run.Reason)
+		err := withCronsHub(ctx, cronjob, func(ctx context.Context) {
+			reportMissedRun(ctx, cronjob, cronsMonitorData, run)
+		})
+		if err != nil {
+			logger.Error().Msgf("Cannot report the missed run of CronJob %s/%s: %v", cronjob.Namespace, cronjob.Name, err)
+		}
+	}
+}
+
+// Returns the first scheduled run after the last one that did not start
+// on time, nil if the next run is not due yet
+func getMissedRun(cronjob *batchv1.CronJob, cronsMonitorData *CronsMonitorData, now time.Time) *missedRun {
+	schedule, concurrencyPolicy := cronsMonitorData.getSchedule()
+	if schedule == nil || isCronJobSuspended(cronjob) {
+		return nil
+	}
+	// With the Forbid policy, the runs are skipped while a run is active (reported on its end)
+	if concurrencyPolicy == batchv1.ForbidConcurrent && len(cronjob.Status.Active) > 0 {
+		return nil
+	}
+
+	lastScheduleTime := cronjob.CreationTimestamp.Time
+	if cronjob.Status.LastScheduleTime != nil {
+		lastScheduleTime = cronjob.Status.LastScheduleTime.Time
+	}
+	run := &missedRun{Kind: MissedRunLate, Margin: defaultLateRunMargin}
+	if deadline := cronjob.Spec.StartingDeadlineSeconds; deadline != nil {
+		run.Kind = MissedRunMissed
+		run.Margin = time.Duration(*deadline) * time.Second
+	}
+	run.ScheduledTime = schedule.Next(lastScheduleTime)
+	if run.ScheduledTime.IsZero() || !now.After(run.ScheduledTime.Add(run.Margin)) {
+		return nil
+	}
+	run.Count = countScheduledTimes(schedule, lastScheduleTime, now.Add(-run.Margin))
+	return run
+}
+
+// Returns the reason and the message of the latest warning event of the
+// CronJob since the scheduled time, e.g. FailedCreate when the quota of the
+// namespace is exceeded
+func getCronJobWarning(ctx context.Context, cronjob *batchv1.CronJob, since time.Time) (string, string) {
+	noEventReason, noEventMessage := "Unknown", "no warning event about the CronJob, the cronjob controller may not be running"
+	clientset, err := getClientsetFromContext(ctx)
+	if err != nil {
+		return noEventReason, noEventMessage
+	}
+	selector := fields.Set{
+		"involvedObject.kind": KindCronjob,
+		"involvedObject.name": cronjob.Name,
+		"type":                v1.EventTypeWarning,
+	}.AsSelector().String()
+	events, err := clientset.CoreV1().Events(cronjob.Namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().Msgf("Cannot list the events of CronJob %s/%s: %v", cronjob.Namespace, cronjob.Name, err)
+		return noEventReason, noEventMessage
+	}
+
+	warnings := make([]v1.Event, 0, len(events.Items))
+	for _, event := range events.Items {
+		if event.InvolvedObject.Name != cronjob.Name || event.Type != v1.EventTypeWarning {
+			continue
+		}
+		if !getEventTime(&event).Before(since) {
+			warnings = append(warnings, event)
+		}
+	}
+	if len(warnings) == 0 {
+		return noEventReason, noEventMessage
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		return getEventTime(&warnings[i]).Before(getEventTime(&warnings[j]))
+	})
+	latest := warnings[len(warnings)-1]
+	return latest.Reason, latest.Message
+}
+
+// Returns the last time the event occurred
+func getEventTime(event *v1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+// Sends a warning about the run that did not start, linked to the monitor
+func reportMissedRun(ctx context.Context, cronjob *batchv1.CronJob, cronsMonitorData *CronsMonitorData, run *missedRun) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		return
+	}
+	monitorSlug, _ := cronsMonitorData.getMonitor()
+	message := fmt.Sprintf("The scheduled run of CronJob %s at %s did not start within %s: %s: %s",
+		cronjob.Name, run.ScheduledTime.Format(time.RFC3339), run.Margin, run.Reason, run.Message)
+	if run.Kind == MissedRunLate {
+		message = fmt.Sprintf("The scheduled run of CronJob %s at %s is late by more than %s: %s: %s",
+			cronjob.Name, run.ScheduledTime.Format(time.RFC3339), run.Margin, run.Reason, run.Message)
+	}
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelWarning)
+		scope.SetContext("Monitor", sentry.Context{
+			"Slug": monitorSlug,
+		})
+		scope.SetContext("Missed Run", sentry.Context{
+			"Kind":           run.Kind,
+			"Scheduled Time": run.ScheduledTime.Format(time.RFC3339),
+			"Margin":         run.Margin.String(),
+			"Missed Times":   run.Count,
+			"Reason":         run.Reason,
+			"Message":        run.Message,
+		})
+		setTagIfNotEmpty(scope, "namespace", cronjob.Namespace)
+		setTagIfNotEmpty(scope, "cronjob_name", cronjob.Name)
+		setTagIfNotEmpty(scope, "reason", run.Reason)
+		scope.SetFingerprint([]string{"missed-run", monitorSlug, run.Reason})
+		captureCronsMessage(ctx, scope, message)
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetMissedRun(t *testing.T) {
+	lastScheduleTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	deadline := int64(60)
+	suspend := true
+
+	testCases := []struct {
+		name          string
+		deadline      *int64
+		suspend       *bool
+		now           time.Time
+		expectedKind  string
+		expectedCount int
+	}{
+		{"next run not due", &deadline, nil, time.Date(2024, 3, 1, 10, 5, 30, 0, time.UTC), "", 0},
+		{"missed after the deadline", &deadline, nil, time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), MissedRunMissed, 1},
+		{"several missed times", &deadline, nil, time.Date(2024, 3, 1, 10, 16, 0, 0, time.UTC), MissedRunMissed, 3},
+		{"late without a deadline", nil, nil, time.Date(2024, 3, 1, 10, 6, 30, 0, time.UTC), MissedRunLate, 1},
+		{"suspended", &deadline, &suspend, time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC), "", 0},
+	}
+	for _, testCase := range testCases {
+		cronjob := newCronsTestCronJob()
+		cronjob.Spec.StartingDeadlineSeconds = testCase.deadline
+		cronjob.Spec.Suspend = testCase.suspend
+		cronjob.Status.LastScheduleTime = &lastScheduleTime
+
+		run := getMissedRun(cronjob, NewCronsMonitorDataFromCronJob(context.Background(), cronjob), testCase.now)
+		if testCase.expectedKind == "" {
+			if run != nil {
+				t.Errorf("%s: no missed run expected: %#v", testCase.name, run)
+			}
+			continue
+		}
+		if run == nil {
+			t.Errorf("%s: a missed run expected", testCase.name)
+			continue
+		}
+		if run.Kind != testCase.expectedKind || run.Count != testCase.expectedCount || !run.ScheduledTime.Equal(lastScheduleTime.Add(5*time.Minute)) {
+			t.Errorf("%s: unexpected missed run: %#v", testCase.name, run)
+		}
+	}
+}
+
+func TestCheckMissedCronJobRuns(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	deadline := int64(60)
+	cronjob.Spec.StartingDeadlineSeconds = &deadline
+	lastScheduleTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	cronjob.Status.LastScheduleTime = &lastScheduleTime
+	quotaEvent := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "backup.quota", Namespace: "default"},
+		InvolvedObject: v1.ObjectReference{Kind: KindCronjob, Name: "backup", Namespace: "default"},
+		Type:           v1.EventTypeWarning,
+		Reason:         "FailedCreate",
+		Message:        "Error creating job: jobs.batch \"backup-28487105\" is forbidden: exceeded quota: compute",
+		LastTimestamp:  metav1.NewTime(time.Date(2024, 3, 1, 10, 5, 1, 0, time.UTC)),
+	}
+	oldEvent := quotaEvent.DeepCopy()
+	oldEvent.Name = "backup.old"
+	oldEvent.Reason = "TooManyMissedTimes"
+	oldEvent.LastTimestamp = metav1.NewTime(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+	ctx, transport, _ := newCronsTestContext(t, cronjob, quotaEvent, oldEvent)
+
+	now := time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC)
+	checkMissedCronJobRuns(ctx, []interface{}{cronjob}, now)
+	// The same run is reported once
+	checkMissedCronJobRuns(ctx, []interface{}{cronjob}, now.Add(missedRunsCheckInterval))
+
+	events := transport.Events()
+	if len(events) != 1 {
+		t.Fatalf("Events expected: 1, actual: %#v", events)
+	}
+	event := events[0]
+	if event.Level != sentry.LevelWarning || !strings.Contains(event.Message, "did not start within 1m0s: FailedCreate: ") || !strings.Contains(event.Message, "exceeded quota") {
+		t.Errorf("Unexpected message: %q", event.Message)
+	}
+	if event.Tags["reason"] != "FailedCreate" || event.Contexts["Monitor"]["Slug"] != "backup" {
+		t.Errorf("Unexpected tags or contexts: %#v, %#v", event.Tags, event.Contexts)
+	}
+	if missed := event.Contexts["Missed Run"]; missed["Scheduled Time"] != "2024-03-01T10:05:00Z" || missed["Kind"] != MissedRunMissed {
+		t.Errorf("Unexpected missed run context: %#v", missed)
+	}
+}
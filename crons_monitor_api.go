@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// Check-ins can only create and update monitors, so the monitors of suspended
+// and deleted CronJobs are muted and disabled with the Sentry API instead
+const defaultSentryAPIURL = "https://sentry.io/api/0/"
+
+const sentryAPITimeout = 10 * time.Second
+
+type sentryMonitorAPI struct {
+	baseURL      string
+	organization string
+	authToken    string
+	client       *http.Client
+}
+
+// Returns the client of the monitors API, or nil if SENTRY_K8S_SENTRY_AUTH_TOKEN
+// and SENTRY_K8S_SENTRY_ORG are not set
+func getSentryMonitorAPI() *sentryMonitorAPI {
+	authToken := strings.TrimSpace(os.Getenv("SENTRY_K8S_SENTRY_AUTH_TOKEN"))
+	organization := strings.TrimSpace(os.Getenv("SENTRY_K8S_SENTRY_ORG"))
+	if authToken == "" || organization == "" {
+		return nil
+	}
+	baseURL := strings.TrimSpace(os.Getenv("SENTRY_K8S_SENTRY_API_URL"))
+	if baseURL == "" {
+		baseURL = defaultSentryAPIURL
+	}
+	return &sentryMonitorAPI{
+		baseURL:      strings.TrimSuffix(baseURL, "/") + "/",
+		organization: organization,
+		authToken:    authToken,
+		client:       &http.Client{Timeout: sentryAPITimeout},
+	}
+}
+
+// Updates the fields of the monitor
+func (api *sentryMonitorAPI) updateMonitor(ctx context.Context, slug string, fields map[string]interface{}) error {
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%sorganizations/%s/monitors/%s/", api.baseURL, url.PathEscape(api.organization), url.PathEscape(slug))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+api.authToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := api.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", response.Status)
+	}
+	return nil
+}
+
+func (api *sentryMonitorAPI) setMonitorMuted(ctx context.Context, slug string, muted bool) error {
+	return api.updateMonitor(ctx, slug, map[string]interface{}{"is_muted": muted})
+}
+
+func (api *sentryMonitorAPI) disableMonitor(ctx context.Context, slug string) error {
+	return api.updateMonitor(ctx, slug, map[string]interface{}{"status": "disabled"})
+}
+
+// Replaces the config of the monitor, so that a changed schedule
+// applies before the next check-in
+func (api *sentryMonitorAPI) updateMonitorConfig(ctx context.Context, slug string, monitorConfig *sentry.MonitorConfig) error {
+	config, err := getMonitorAPIConfig(monitorConfig)
+	if err != nil {
+		return err
+	}
+	return api.updateMonitor(ctx, slug, map[string]interface{}{"config": config})
+}
+
+// The API expects the schedule as "schedule_type" and "schedule" fields,
+// the other fields have the same names as in check-ins
+func getMonitorAPIConfig(monitorConfig *sentry.MonitorConfig) (map[string]interface{}, error) {
+	raw, err := json.Marshal(monitorConfig)
+	if err != nil {
+		return nil, err
+	}
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	if schedule, ok := config["schedule"].(map[string]interface{}); ok {
+		config["schedule_type"] = schedule["type"]
+		config["schedule"] = schedule["value"]
+	}
+	return config, nil
+}
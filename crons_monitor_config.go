@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// Annotations on a CronJob that override the monitor config
+const (
+	MonitorScheduleAnnotation              = "k8s.sentry.io/monitor-schedule"
+	MonitorTimezoneAnnotation              = "k8s.sentry.io/monitor-timezone"
+	MonitorCheckInMarginAnnotation         = "k8s.sentry.io/monitor-checkin-margin"
+	MonitorMaxRuntimeAnnotation            = "k8s.sentry.io/monitor-max-runtime"
+	MonitorFailureIssueThresholdAnnotation = "k8s.sentry.io/monitor-failure-issue-threshold"
+	MonitorRecoveryThresholdAnnotation     = "k8s.sentry.io/monitor-recovery-threshold"
+)
+
+// Creates the monitor data of a CronJob, with the monitor config
+// that matches what Kubernetes enforces for the CronJob
+func NewCronsMonitorDataFromCronJob(ctx context.Context, cronjob *batchv1.CronJob) *CronsMonitorData {
+	monitorData := NewCronsMonitorData(getMonitorSlug(cronjob), cronjob.Spec.Schedule, cronjob.Spec.JobTemplate.Spec.Completions)
+	monitorData.monitorConfig = getMonitorConfig(ctx, cronjob)
+	monitorData.concurrencyPolicy = cronjob.Spec.ConcurrencyPolicy
+	schedule, err := parseCronJobSchedule(cronjob)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Msgf("Cannot parse the schedule of CronJob %s/%s: %v", cronjob.Namespace, cronjob.Name, err)
+	} else {
+		monitorData.schedule = schedule
+	}
+	return monitorData
+}
+
+// Parses the schedule of a CronJob the way Kubernetes runs it: in the
+// timezone of the spec (or of the schedule prefix), otherwise in UTC
+func parseCronJobSchedule(cronjob *batchv1.CronJob) (cron.Schedule, error) {
+	var timezone string
+	if cronjob.Spec.TimeZone != nil {
+		timezone = *cronjob.Spec.TimeZone
+	}
+	return parseSchedule(cronjob.Spec.Schedule, timezone)
+}
+
+// Parses a crontab schedule in the timezone (or in the timezone of the
+// schedule prefix), otherwise in UTC
+func parseSchedule(rawSchedule string, specTimezone string) (cron.Schedule, error) {
+	schedule, timezone := splitScheduleTimezone(rawSchedule)
+	if specTimezone != "" {
+		timezone = specTimezone
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return cron.ParseStandard(fmt.Sprintf("CRON_TZ=%s %s", timezone, schedule))
+}
+
+// Returns the monitor config of a CronJob:
+//   - the schedule and the timezone are taken from the spec (a CRON_TZ or TZ
+//     prefix of the schedule is used as the timezone too);
+//   - the check-in margin is taken from startingDeadlineSeconds, because a
+//     run that does not start by then is skipped by Kubernetes;
+//   - the max runtime is taken from the activeDeadlineSeconds of the jobs,
+//     because a job that runs longer is failed by Kubernetes.
+//
+// Every field (and the failure and recovery thresholds) can be overridden with annotations.
+func getMonitorConfig(ctx context.Context, cronjob *batchv1.CronJob) *sentry.MonitorConfig {
+	var timezone string
+	if cronjob.Spec.TimeZone != nil {
+		timezone = *cronjob.Spec.TimeZone
+	}
+	return newMonitorConfig(ctx, cronjob.Spec.Schedule, timezone,
+		cronjob.Spec.StartingDeadlineSeconds, cronjob.Spec.JobTemplate.Spec.ActiveDeadlineSeconds, cronjob.Annotations)
+}
+
+// Returns the monitor config of a schedule (in the timezone, if set), with the
+// check-in margin and the max runtime from the deadlines (in seconds, if set),
+// overridden by the annotations of the schedule object
+func newMonitorConfig(ctx context.Context, rawSchedule string, specTimezone string, startingDeadline *int64, activeDeadline *int64, annotations map[string]string) *sentry.MonitorConfig {
+	logger := zerolog.Ctx(ctx)
+
+	schedule, timezone := splitScheduleTimezone(rawSchedule)
+	if specTimezone != "" {
+		timezone = specTimezone
+	}
+	monitorConfig := &sentry.MonitorConfig{Timezone: timezone}
+	if startingDeadline != nil {
+		monitorConfig.CheckInMargin = secondsToMinutes(*startingDeadline)
+	}
+	if activeDeadline != nil {
+		monitorConfig.MaxRuntime = secondsToMinutes(*activeDeadline)
+	}
+
+	if value := strings.TrimSpace(annotations[MonitorScheduleAnnotation]); value != "" {
+		schedule = value
+	}
+	if value := strings.TrimSpace(annotations[MonitorTimezoneAnnotation]); value != "" {
+		if _, err := time.LoadLocation(value); err != nil {
+			logger.Warn().Msgf("Invalid value of the %s annotation: %q", MonitorTimezoneAnnotation, value)
+		} else {
+			monitorConfig.Timezone = value
+		}
+	}
+	for annotation, field := range map[string]*int64{
+		MonitorCheckInMarginAnnotation:         &monitorConfig.CheckInMargin,
+		MonitorMaxRuntimeAnnotation:            &monitorConfig.MaxRuntime,
+		MonitorFailureIssueThresholdAnnotation: &monitorConfig.FailureIssueThreshold,
+		MonitorRecoveryThresholdAnnotation:     &monitorConfig.RecoveryThreshold,
+	} {
+		rawValue, ok := annotations[annotation]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(rawValue), 10, 64)
+		if err != nil || value < 0 {
+			logger.Warn().Msgf("Invalid value of the %s annotation: %q", annotation, rawValue)
+			continue
+		}
+		*field = value
+	}
+
+	monitorConfig.Schedule = sentry.CrontabSchedule(schedule)
+	return monitorConfig
+}
+
+// Splits a "CRON_TZ=<timezone> <schedule>" (or "TZ=") schedule
+func splitScheduleTimezone(schedule string) (string, string) {
+	schedule = strings.TrimSpace(schedule)
+	for _, prefix := range []string{"CRON_TZ=", "TZ="} {
+		if !strings.HasPrefix(schedule, prefix) {
+			continue
+		}
+		timezone, rest, found := strings.Cut(strings.TrimPrefix(schedule, prefix), " ")
+		if found {
+			return strings.TrimSpace(rest), timezone
+		}
+	}
+	return schedule, ""
+}
+
+// Sentry expects minutes, rounded up so that the limits are not stricter than in Kubernetes
+func secondsToMinutes(seconds int64) int64 {
+	return (seconds + 59) / 60
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetMonitorConfig(t *testing.T) {
+	timezone := "Europe/Vienna"
+	var startingDeadlineSeconds int64 = 90
+	var activeDeadlineSeconds int64 = 600
+
+	cronjob := &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default"},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                "*/5 * * * *",
+			TimeZone:                &timezone,
+			StartingDeadlineSeconds: &startingDeadlineSeconds,
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{ActiveDeadlineSeconds: &activeDeadlineSeconds},
+			},
+		},
+	}
+
+	monitorConfig := getMonitorConfig(context.Background(), cronjob)
+	expected := sentry.MonitorConfig{
+		Schedule:      sentry.CrontabSchedule("*/5 * * * *"),
+		Timezone:      "Europe/Vienna",
+		CheckInMargin: 2,
+		MaxRuntime:    10,
+	}
+	if *monitorConfig != expected {
+		t.Errorf("Monitor config expected: %#v, actual: %#v", expected, *monitorConfig)
+	}
+
+	// Annotations override the spec
+	cronjob.Annotations = map[string]string{
+		MonitorScheduleAnnotation:              "0 * * * *",
+		MonitorTimezoneAnnotation:              "UTC",
+		MonitorCheckInMarginAnnotation:         "5",
+		MonitorMaxRuntimeAnnotation:            "invalid",
+		MonitorFailureIssueThresholdAnnotation: "3",
+		MonitorRecoveryThresholdAnnotation:     "2",
+	}
+	monitorConfig = getMonitorConfig(context.Background(), cronjob)
+	expected = sentry.MonitorConfig{
+		Schedule:              sentry.CrontabSchedule("0 * * * *"),
+		Timezone:              "UTC",
+		CheckInMargin:         5,
+		MaxRuntime:            10,
+		FailureIssueThreshold: 3,
+		RecoveryThreshold:     2,
+	}
+	if *monitorConfig != expected {
+		t.Errorf("Monitor config expected: %#v, actual: %#v", expected, *monitorConfig)
+	}
+
+	// The thresholds are sent with the check-ins
+	client, err := sentry.NewClient(sentry.ClientOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkinEvent := client.EventFromCheckIn(&sentry.CheckIn{MonitorSlug: "backup", Status: sentry.CheckInStatusOK}, monitorConfig)
+	payload, err := json.Marshal(checkinEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"failure_issue_threshold":3`) || !strings.Contains(string(payload), `"recovery_threshold":2`) {
+		t.Errorf("The thresholds are missing from the check-in: %s", payload)
+	}
+}
+
+func TestSplitScheduleTimezone(t *testing.T) {
+	schedule, timezone := splitScheduleTimezone("CRON_TZ=America/New_York 0 6 * * *")
+	if schedule != "0 6 * * *" || timezone != "America/New_York" {
+		t.Errorf("Unexpected schedule %q and timezone %q", schedule, timezone)
+	}
+	schedule, timezone = splitScheduleTimezone("@hourly")
+	if schedule != "@hourly" || timezone != "" {
+		t.Errorf("Unexpected schedule %q and timezone %q", schedule, timezone)
+	}
+}
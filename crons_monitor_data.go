@@ -1,21 +1,38 @@
 package main
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/robfig/cron/v3"
 	batchv1 "k8s.io/api/batch/v1"
 )
 
+// Finished jobs are kept for a while, so that the updates that arrive after
+// the ending check-in (before the status annotation) do not check in again
+const finishedJobRetention = 10 * time.Minute
+
+// The maximum number of jobs tracked for a CronJob
+const maxTrackedJobs = 100
+
 // Struct associated with a job
 type CronsJobData struct {
 	CheckinID sentry.EventID
+	// The status of the ending check-in, empty while the job is running
+	Status sentry.CheckInStatus
+	// Whether the job was created by hand from the CronJob
+	manual     bool
+	startedAt  time.Time
+	finishedAt time.Time
 }
 
 // Constructor for cronsMonitorData
 func NewCronsJobData(checkinId sentry.EventID) *CronsJobData {
 	return &CronsJobData{
 		CheckinID: checkinId,
+		startedAt: time.Now(),
 	}
 }
 
@@ -30,6 +47,11 @@ type CronsMonitorData struct {
 	monitorConfig       *sentry.MonitorConfig
 	JobDatas            map[string]*CronsJobData
 	requiredCompletions int32
+	// The schedule that Kubernetes follows (nil if it cannot be parsed)
+	schedule          cron.Schedule
+	concurrencyPolicy batchv1.ConcurrencyPolicy
+	// The scheduled time of the last run reported as missed or late
+	missedRunReported time.Time
 }
 
 // Constructor for cronsMonitorData
@@ -54,16 +76,151 @@ func NewCronsMonitorData(monitorSlug string, schedule string, completions *int32
 	}
 }
 
+// Returns the slug and the config of the monitor
+func (c *CronsMonitorData) getMonitor() (string, *sentry.MonitorConfig) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.MonitorSlug, c.monitorConfig
+}
+
+// Returns the schedule of the CronJob and its concurrency policy
+func (c *CronsMonitorData) getSchedule() (cron.Schedule, batchv1.ConcurrencyPolicy) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.schedule, c.concurrencyPolicy
+}
+
+// Applies the monitor and the CronJob spec of the updated monitor data,
+// the tracked jobs are kept
+func (c *CronsMonitorData) update(updated *CronsMonitorData) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.MonitorSlug = updated.MonitorSlug
+	c.monitorConfig = updated.monitorConfig
+	c.requiredCompletions = updated.requiredCompletions
+	c.schedule = updated.schedule
+	c.concurrencyPolicy = updated.concurrencyPolicy
+}
+
+// Records that the run scheduled at the time was reported as missed,
+// returns false if it was already reported
+func (c *CronsMonitorData) markMissedRun(scheduledTime time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !scheduledTime.After(c.missedRunReported) {
+		return false
+	}
+	c.missedRunReported = scheduledTime
+	return true
+}
+
+// Returns the slug and the config of the monitor that the runs of the job
+// are reported to: manual runs can be reported to a separate monitor
+func (c *CronsMonitorData) getMonitorForJob(job *batchv1.Job) (string, *sentry.MonitorConfig) {
+	return c.getMonitorForRun(isManualJob(job))
+}
+
+func (c *CronsMonitorData) getMonitorForRun(manual bool) (string, *sentry.MonitorConfig) {
+	monitorSlug, monitorConfig := c.getMonitor()
+	if manual && getManualJobsMode() == ManualJobsSeparate {
+		// Manual runs have no schedule, the monitor is not upserted
+		return sanitizeMonitorSlug(monitorSlug + manualMonitorSlugSuffix), nil
+	}
+	return monitorSlug, monitorConfig
+}
+
+// Returns the jobs that did not finish yet
+func (c *CronsMonitorData) getRunningJobs() map[string]CronsJobData {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	runningJobs := make(map[string]CronsJobData)
+	for jobName, jobData := range c.JobDatas {
+		if jobData.Status == "" {
+			runningJobs[jobName] = *jobData
+		}
+	}
+	return runningJobs
+}
+
 // Add a job to the crons monitor
 func (c *CronsMonitorData) addJob(job *batchv1.Job, checkinID sentry.EventID) error {
+	c.addRun(job.Name, isManualJob(job), checkinID)
+	return nil
+}
+
+// Adds a run of another scheduler (e.g. an Argo Workflow) by the name of its object
+func (c *CronsMonitorData) addRun(runName string, manual bool, checkinID sentry.EventID) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.JobDatas[job.Name] = NewCronsJobData(checkinID)
-	return nil
+	jobData := NewCronsJobData(checkinID)
+	jobData.manual = manual
+	c.JobDatas[runName] = jobData
+	c.pruneJobs(time.Now())
+}
+
+// Removes a job, e.g. when it is deleted
+func (c *CronsMonitorData) removeJob(jobName string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.JobDatas, jobName)
+}
+
+// Removes the jobs that finished more than finishedJobRetention ago, and the
+// oldest jobs above maxTrackedJobs (finished jobs first). The mutex must be held.
+func (c *CronsMonitorData) pruneJobs(now time.Time) {
+	for jobName, jobData := range c.JobDatas {
+		if jobData.Status != "" && now.Sub(jobData.finishedAt) > finishedJobRetention {
+			delete(c.JobDatas, jobName)
+		}
+	}
+	if len(c.JobDatas) <= maxTrackedJobs {
+		return
+	}
+	jobNames := make([]string, 0, len(c.JobDatas))
+	for jobName := range c.JobDatas {
+		jobNames = append(jobNames, jobName)
+	}
+	sort.Slice(jobNames, func(i, j int) bool {
+		first, second := c.JobDatas[jobNames[i]], c.JobDatas[jobNames[j]]
+		if (first.Status != "") != (second.Status != "") {
+			return first.Status != ""
+		}
+		return first.startedAt.Before(second.startedAt)
+	})
+	for _, jobName := range jobNames[:len(jobNames)-maxTrackedJobs] {
+		delete(c.JobDatas, jobName)
+	}
+}
+
+func (c *CronsMonitorData) getJob(jobName string) (*CronsJobData, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	jobData, ok := c.JobDatas[jobName]
+	return jobData, ok
+}
+
+// Records the ending check-in of a job, returns false
+// if the ending check-in was already sent
+func (c *CronsMonitorData) finishJob(jobName string, checkinID sentry.EventID, status sentry.CheckInStatus) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	jobData, ok := c.JobDatas[jobName]
+	if !ok {
+		jobData = NewCronsJobData(checkinID)
+		c.JobDatas[jobName] = jobData
+	}
+	if jobData.Status != "" {
+		return false
+	}
+	jobData.Status = status
+	jobData.finishedAt = time.Now()
+	c.pruneJobs(jobData.finishedAt)
+	return true
 }
 
 // Wrapper struct over crons monitor map that
-// handles synchronization
+// handles synchronization. The map is keyed by the
+// namespace and name of the CronJob (see cronsMonitorKey).
 type CronsMetaData struct {
 	mutex               *sync.RWMutex
 	cronsMonitorDataMap map[string]*CronsMonitorData
@@ -76,21 +233,21 @@ func NewCronsMetaData() *CronsMetaData {
 	}
 }
 
-func (c *CronsMetaData) addCronsMonitorData(cronjobName string, newCronsMonitorData *CronsMonitorData) {
+func (c *CronsMetaData) addCronsMonitorData(key string, newCronsMonitorData *CronsMonitorData) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.cronsMonitorDataMap[cronjobName] = newCronsMonitorData
+	c.cronsMonitorDataMap[key] = newCronsMonitorData
 }
 
-func (c *CronsMetaData) deleteCronsMonitorData(cronjobName string) {
+func (c *CronsMetaData) deleteCronsMonitorData(key string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	delete(c.cronsMonitorDataMap, cronjobName)
+	delete(c.cronsMonitorDataMap, key)
 }
 
-func (c *CronsMetaData) getCronsMonitorData(cronjobName string) (*CronsMonitorData, bool) {
+func (c *CronsMetaData) getCronsMonitorData(key string) (*CronsMonitorData, bool) {
 	c.mutex.RLock()
 	defer c.mutex.RUnlock()
-	cronsMonitorData, ok := c.cronsMonitorDataMap[cronjobName]
+	cronsMonitorData, ok := c.cronsMonitorDataMap[key]
 	return cronsMonitorData, ok
 }
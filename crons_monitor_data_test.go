@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	batchv1 "k8s.io/api/batch/v1"
@@ -154,3 +156,37 @@ func TestGetCronsMonitorData(t *testing.T) {
 		t.Errorf("Failed to get correct cronsMonitorData to map")
 	}
 }
+
+func TestPruneJobs(t *testing.T) {
+	cronsMonitorData := NewCronsMonitorData("cronjob-slug", "* * * * *", nil)
+	for i := 0; i < maxTrackedJobs+5; i++ {
+		job := &batchv1.Job{ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("job-%d", i)}}
+		cronsMonitorData.addJob(job, sentry.EventID(fmt.Sprintf("%032d", i)))
+	}
+	if len(cronsMonitorData.JobDatas) != maxTrackedJobs {
+		t.Errorf("Tracked jobs expected: %d, actual: %d", maxTrackedJobs, len(cronsMonitorData.JobDatas))
+	}
+
+	// Finished jobs are removed first when the limit is reached
+	finishedJob := "job-50"
+	cronsMonitorData.finishJob(finishedJob, sentry.EventID(fmt.Sprintf("%032d", 50)), sentry.CheckInStatusOK)
+	cronsMonitorData.addJob(&batchv1.Job{ObjectMeta: v1.ObjectMeta{Name: "job-new"}}, sentry.EventID(fmt.Sprintf("%032d", 1000)))
+	if _, ok := cronsMonitorData.getJob(finishedJob); ok {
+		t.Errorf("The finished job should be removed first")
+	}
+	if _, ok := cronsMonitorData.getJob("job-new"); !ok {
+		t.Errorf("The new job should be tracked")
+	}
+
+	// Finished jobs are removed after the retention
+	cronsMonitorData.finishJob("job-new", sentry.EventID(fmt.Sprintf("%032d", 1000)), sentry.CheckInStatusOK)
+	cronsMonitorData.mutex.Lock()
+	cronsMonitorData.pruneJobs(time.Now().Add(finishedJobRetention + time.Minute))
+	cronsMonitorData.mutex.Unlock()
+	if _, ok := cronsMonitorData.getJob("job-new"); ok {
+		t.Errorf("The finished job should be removed after the retention")
+	}
+	if len(cronsMonitorData.JobDatas) != maxTrackedJobs-1 {
+		t.Errorf("The running jobs should be kept, tracked jobs: %d", len(cronsMonitorData.JobDatas))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Annotations set by the agent on the runs of monitored schedules (the jobs of
+// CronJobs, the workflows of Argo CronWorkflows), so that the runs can be
+// closed after a restart of the agent or a leader failover
+const (
+	CheckinIDAnnotation     = "k8s.sentry.io/checkin-id"
+	CheckinStatusAnnotation = "k8s.sentry.io/checkin-status"
+)
+
+// Returns the merge patch that stores the annotations on an object
+func newAnnotationsPatch(annotations map[string]string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+}
+
+// Stores the check-in annotations on the job
+func saveJobCheckinAnnotations(ctx context.Context, job *batchv1.Job, annotations map[string]string) error {
+	clientset, err := getClientsetFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	patch, err := newAnnotationsPatch(annotations)
+	if err != nil {
+		return err
+	}
+	_, err = clientset.BatchV1().Jobs(job.Namespace).Patch(ctx, job.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// Returns the ID of the in-progress check-in of the run: from memory, or
+// from the annotation on its object if the agent was restarted since it started
+func getRunCheckinID(run *cronsRun, cronsMonitorData *CronsMonitorData) (sentry.EventID, bool) {
+	if jobData, ok := cronsMonitorData.getJob(run.object.GetName()); ok {
+		return jobData.getCheckinID(), true
+	}
+	if checkinID := run.object.GetAnnotations()[CheckinIDAnnotation]; checkinID != "" {
+		return sentry.EventID(checkinID), true
+	}
+	return "", false
+}
+
+// Checks in the runs that started or ended while the agent was not running.
+// The run informer reports existing runs before the schedules are known, so
+// this runs again over all the runs once both informers are synced.
+func reconcileCronsRuns(ctx context.Context, scheduler cronsScheduler, runs []interface{}) {
+	logger := zerolog.Ctx(ctx)
+	for _, obj := range runs {
+		if err := checkinCronsRun(ctx, scheduler, obj, EventHandlerUpdate); err != nil {
+			logger.Debug().Msgf("Cannot reconcile a run of a %s: %v", scheduler.scheduleKind(), err)
+		}
+	}
+}
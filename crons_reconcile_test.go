@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Prepares a context with a hub capturing into the returned transport, a fake
+// clientset with the objects, and an empty crons registry with the CronJob
+func newCronsTestContext(t *testing.T, cronjob *batchv1.CronJob, objects ...interface{}) (context.Context, *TransportMock, *fake.Clientset) {
+	transport := &TransportMock{}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: transport,
+		Integrations: func([]sentry.Integration) []sentry.Integration {
+			return []sentry.Integration{}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldCronsMetaData := cronsMetaData
+	t.Cleanup(func() { cronsMetaData = oldCronsMetaData })
+	cronsMetaData = NewCronsMetaData()
+	cronsMetaData.addCronsMonitorData(cronsMonitorKey(cronjob.Namespace, cronjob.Name), NewCronsMonitorDataFromCronJob(context.Background(), cronjob))
+
+	fakeClientset := fake.NewSimpleClientset()
+	for _, obj := range objects {
+		var err error
+		switch obj := obj.(type) {
+		case *batchv1.Job:
+			_, err = fakeClientset.BatchV1().Jobs(obj.Namespace).Create(context.Background(), obj, metav1.CreateOptions{})
+		case *batchv1.CronJob:
+			_, err = fakeClientset.BatchV1().CronJobs(obj.Namespace).Create(context.Background(), obj, metav1.CreateOptions{})
+		case *corev1.Namespace:
+			_, err = fakeClientset.CoreV1().Namespaces().Create(context.Background(), obj, metav1.CreateOptions{})
+		case *corev1.Event:
+			_, err = fakeClientset.CoreV1().Events(obj.Namespace).Create(context.Background(), obj, metav1.CreateOptions{})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+	ctx = sentry.SetHubOnContext(ctx, sentry.NewHub(client, sentry.NewScope()))
+	return ctx, transport, fakeClientset
+}
+
+func newCronsTestCronJob() *batchv1.CronJob {
+	return &batchv1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", UID: types.UID("backup-uid")},
+		Spec:       batchv1.CronJobSpec{Schedule: "*/5 * * * *"},
+	}
+}
+
+func newCronsTestJob(cronjob *batchv1.CronJob, name string) *batchv1.Job {
+	controller := true
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cronjob.Namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind:       KindCronjob,
+				Name:       cronjob.Name,
+				UID:        cronjob.UID,
+				Controller: &controller,
+			}},
+		},
+	}
+}
+
+func TestReconcileResumesCheckinAfterRestart(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-1")
+	job.Status.Active = 1
+	ctx, transport, fakeClientset := newCronsTestContext(t, cronjob, job)
+
+	// The agent starts while the job is running: the missed start is checked in
+	reconcileCronsRuns(ctx, cronJobScheduler{}, []interface{}{job})
+	events := transport.Events()
+	if len(events) != 1 || events[0].CheckIn.Status != sentry.CheckInStatusInProgress {
+		t.Fatalf("An in-progress check-in expected, actual: %#v", events)
+	}
+	checkinID := events[0].CheckIn.ID
+
+	storedJob, err := fakeClientset.BatchV1().Jobs("default").Get(ctx, "backup-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedJob.Annotations[CheckinIDAnnotation] != string(checkinID) {
+		t.Errorf("Check-in ID annotation expected: %s, actual: %q", checkinID, storedJob.Annotations[CheckinIDAnnotation])
+	}
+
+	// The agent restarts, and the job ends
+	cronsMetaData.addCronsMonitorData(cronsMonitorKey("default", "backup"), NewCronsMonitorDataFromCronJob(ctx, cronjob))
+	storedJob.Status = batchv1.JobStatus{
+		Succeeded:  1,
+		Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: "True"}},
+	}
+	reconcileCronsRuns(ctx, cronJobScheduler{}, []interface{}{storedJob})
+	events = transport.Events()
+	if len(events) != 2 || events[1].CheckIn.ID != checkinID || events[1].CheckIn.Status != sentry.CheckInStatusOK {
+		t.Fatalf("The ending check-in expected with ID %s: %#v", checkinID, events)
+	}
+
+	storedJob, err = fakeClientset.BatchV1().Jobs("default").Get(ctx, "backup-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedJob.Annotations[CheckinStatusAnnotation] != string(sentry.CheckInStatusOK) {
+		t.Errorf("Check-in status annotation expected: ok, actual: %q", storedJob.Annotations[CheckinStatusAnnotation])
+	}
+
+	// The run is not closed twice, even after another restart
+	cronsMetaData.addCronsMonitorData(cronsMonitorKey("default", "backup"), NewCronsMonitorDataFromCronJob(ctx, cronjob))
+	storedJob.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: "True"}}
+	reconcileCronsRuns(ctx, cronJobScheduler{}, []interface{}{storedJob})
+	if events = transport.Events(); len(events) != 2 {
+		t.Errorf("Check-ins expected: 2, actual: %d", len(events))
+	}
+}
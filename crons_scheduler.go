@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// A scheduler that starts runs on a schedule, e.g. Kubernetes CronJobs or
+// Argo CronWorkflows. Each scheduler discovers its schedules and detects the
+// start and the end of their runs; the monitors are kept in the crons registry
+// and the runs are checked in the same way for all of them.
+type cronsScheduler interface {
+	// The kind of the objects that define the schedules, e.g. "CronJob"
+	scheduleKind() string
+	// Returns the key of the schedule object in the crons registry and its
+	// monitor data. The key is empty if the object is not a schedule, and
+	// ok is false if the schedule is not monitored.
+	discoverSchedule(ctx context.Context, obj interface{}) (key string, cronsMonitorData *CronsMonitorData, ok bool)
+	// Returns whether the schedule object is suspended, its monitor is then muted
+	isScheduleSuspended(obj interface{}) bool
+	// Returns the run that the object represents (e.g. a Job), false if
+	// the object is not a run of a schedule of this scheduler
+	detectRun(ctx context.Context, obj interface{}) (*cronsRun, bool)
+	// Stores the annotations on the object of the run (see CheckinIDAnnotation)
+	saveRunAnnotations(ctx context.Context, run *cronsRun, annotations map[string]string) error
+	// Returns the outcome of a run that was deleted before it ended
+	getDeletedRunResult(run *cronsRun, cronsMonitorData *CronsMonitorData) *jobRunResult
+	// Reports what happened to the run apart from its check-ins, once it ended
+	reportRunEnd(ctx context.Context, run *cronsRun, cronsMonitorData *CronsMonitorData, checkinID sentry.EventID, result *jobRunResult)
+}
+
+// A run of a schedule
+type cronsRun struct {
+	// The key of the schedule in the crons registry
+	scheduleKey string
+	scheduleUID types.UID
+	// The object of the run, events and check-ins of the run are sent
+	// with the client of this object
+	object metav1.Object
+	// Whether the run was started by hand, not by the schedule
+	manual bool
+	// The outcome of the run, nil while it is running
+	result *jobRunResult
+}
+
+// Kubernetes CronJobs and their Jobs. The runs that the concurrency policy
+// of the CronJob skipped or replaced are reported as warnings.
+type cronJobScheduler struct{}
+
+func (cronJobScheduler) scheduleKind() string {
+	return KindCronjob
+}
+
+func (cronJobScheduler) discoverSchedule(ctx context.Context, obj interface{}) (string, *CronsMonitorData, bool) {
+	cronjob, ok := obj.(*batchv1.CronJob)
+	if !ok {
+		return "", nil, false
+	}
+	key := cronsMonitorKey(cronjob.Namespace, cronjob.Name)
+	if !isScheduleMonitored(ctx, cronjob) {
+		return key, nil, false
+	}
+	return key, NewCronsMonitorDataFromCronJob(ctx, cronjob), true
+}
+
+func (cronJobScheduler) isScheduleSuspended(obj interface{}) bool {
+	cronjob, ok := obj.(*batchv1.CronJob)
+	return ok && isCronJobSuspended(cronjob)
+}
+
+func (cronJobScheduler) detectRun(ctx context.Context, obj interface{}) (*cronsRun, bool) {
+	job, ok := obj.(*batchv1.Job)
+	if !ok {
+		return nil, false
+	}
+	cronjobRef := metav1.GetControllerOf(job)
+	if cronjobRef == nil || cronjobRef.Kind != KindCronjob {
+		return nil, false
+	}
+	// The annotation of the Namespace may have changed since the CronJob was added
+	if cronjobObj, found := findObject(ctx, KindCronjob, job.Namespace, cronjobRef.Name); found {
+		if cronjob, ok := cronjobObj.(*batchv1.CronJob); ok && !isScheduleMonitored(ctx, cronjob) {
+			return nil, false
+		}
+	}
+	run := &cronsRun{
+		scheduleKey: cronsMonitorKey(job.Namespace, cronjobRef.Name),
+		scheduleUID: cronjobRef.UID,
+		object:      job,
+		manual:      isManualJob(job),
+	}
+
+	// The completions of the CronJob's job template, the job's own if the CronJob is not known
+	requiredCompletions := int32(1)
+	if cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(run.scheduleKey); ok {
+		requiredCompletions = cronsMonitorData.requiredCompletions
+	} else if job.Spec.Completions != nil {
+		requiredCompletions = *job.Spec.Completions
+	}
+	run.result = getJobRunResult(job, requiredCompletions)
+	return run, true
+}
+
+func (cronJobScheduler) saveRunAnnotations(ctx context.Context, run *cronsRun, annotations map[string]string) error {
+	return saveJobCheckinAnnotations(ctx, run.object.(*batchv1.Job), annotations)
+}
+
+// The job was replaced by a newer run (with the Replace concurrency
+// policy), or deleted by hand
+func (cronJobScheduler) getDeletedRunResult(run *cronsRun, cronsMonitorData *CronsMonitorData) *jobRunResult {
+	job := run.object.(*batchv1.Job)
+	result := &jobRunResult{Status: sentry.CheckInStatusError, Reason: "Deleted", Retries: job.Status.Failed}
+	if job.Status.StartTime != nil {
+		result.Duration = time.Since(job.Status.StartTime.Time)
+	}
+	if _, concurrencyPolicy := cronsMonitorData.getSchedule(); concurrencyPolicy == batchv1.ReplaceConcurrent {
+		result.Reason = "Replaced"
+	}
+	return result
+}
+
+func (cronJobScheduler) reportRunEnd(ctx context.Context, run *cronsRun, cronsMonitorData *CronsMonitorData, checkinID sentry.EventID, result *jobRunResult) {
+	job := run.object.(*batchv1.Job)
+
+	// With the Forbid policy, the scheduled times that passed while
+	// the job was running did not start a run
+	schedule, concurrencyPolicy := cronsMonitorData.getSchedule()
+	if concurrencyPolicy == batchv1.ForbidConcurrent && schedule != nil && job.Status.StartTime != nil {
+		startTime := job.Status.StartTime.Time
+		if skipped := countScheduledTimes(schedule, startTime, startTime.Add(result.Duration)); skipped > 0 {
+			reportConcurrencyOutcome(ctx, job, cronsMonitorData, checkinID, fmt.Sprintf(
+				"%d scheduled run(s) of CronJob %s were not started on time while job %s was active (concurrencyPolicy: Forbid)",
+				skipped, getCronJobName(job), job.Name))
+		}
+	}
+	if result.Reason == "Replaced" {
+		reportConcurrencyOutcome(ctx, job, cronsMonitorData, checkinID, fmt.Sprintf(
+			"Job %s of CronJob %s was replaced by a newer run before it finished (concurrencyPolicy: Replace)",
+			job.Name, getCronJobName(job)))
+	}
+}
+
+// Adds the handlers that keep the crons registry in sync with the schedules
+// of the scheduler, and that check in their runs
+func addCronsSchedulerHandlers(ctx context.Context, scheduler cronsScheduler, scheduleInformer cache.SharedIndexInformer, runInformer cache.SharedIndexInformer) {
+	logger := zerolog.Ctx(ctx)
+
+	scheduleInformer.AddEventHandler(trackEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			syncCronsSchedule(ctx, scheduler, nil, obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !isResourceVersionChanged(oldObj, newObj) {
+				return
+			}
+			syncCronsSchedule(ctx, scheduler, oldObj, newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			object, ok := obj.(metav1.Object)
+			if !ok {
+				return
+			}
+			if key, _, _ := scheduler.discoverSchedule(ctx, obj); key != "" {
+				logger.Debug().Msgf("DELETE: %s deleted from Store: %s\n", scheduler.scheduleKind(), object.GetName())
+				removeCronsMonitor(ctx, key, object)
+			}
+		},
+	}))
+
+	checkin := func(obj interface{}, eventHandlerType EventHandlerType) {
+		if err := checkinCronsRun(ctx, scheduler, obj, eventHandlerType); err != nil {
+			logger.Debug().Msgf("Cannot check in the run of a %s: %v", scheduler.scheduleKind(), err)
+		}
+	}
+	runInformer.AddEventHandler(trackEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			checkin(obj, EventHandlerAdd)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !isResourceVersionChanged(oldObj, newObj) {
+				return
+			}
+			checkin(newObj, EventHandlerUpdate)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			checkin(obj, EventHandlerDelete)
+		},
+	}))
+}
+
+// Returns false for the periodic resyncs of the informers
+func isResourceVersionChanged(oldObj interface{}, newObj interface{}) bool {
+	oldObject, oldOk := oldObj.(metav1.Object)
+	newObject, newOk := newObj.(metav1.Object)
+	return !oldOk || !newOk || oldObject.GetResourceVersion() != newObject.GetResourceVersion()
+}
+
+// Adds the schedule to the crons registry, updates it, or removes it if it
+// is no longer monitored (see removeCronsMonitor); oldObj is nil when the
+// schedule is added. The runs that are tracked are kept. A changed monitor
+// config is sent to Sentry, the monitor of a replaced slug is disabled, and
+// the monitor is muted while the schedule is suspended.
+func syncCronsSchedule(ctx context.Context, scheduler cronsScheduler, oldObj interface{}, obj interface{}) {
+	logger := zerolog.Ctx(ctx)
+	key, updated, monitored := scheduler.discoverSchedule(ctx, obj)
+	if key == "" {
+		return
+	}
+	cronsMonitorData, found := cronsMetaData.getCronsMonitorData(key)
+	switch {
+	case !monitored && found:
+		logger.Info().Msgf("%s %s is no longer monitored", scheduler.scheduleKind(), key)
+		if object, ok := obj.(metav1.Object); ok {
+			removeCronsMonitor(ctx, key, object)
+		}
+	case !monitored:
+		logger.Debug().Msgf("%s %s is not monitored\n", scheduler.scheduleKind(), key)
+	case found:
+		oldSlug, oldConfig := cronsMonitorData.getMonitor()
+		cronsMonitorData.update(updated)
+		newSlug, newConfig := cronsMonitorData.getMonitor()
+		if oldSlug != newSlug || !reflect.DeepEqual(oldConfig, newConfig) {
+			logger.Info().Msgf("The monitor of %s %s changed, slug: %s", scheduler.scheduleKind(), key, newSlug)
+			// Without the API, the monitor is updated by the next check-in
+			if api := getSentryMonitorAPI(); api != nil {
+				if err := api.updateMonitorConfig(ctx, newSlug, newConfig); err != nil {
+					logger.Warn().Msgf("Cannot update the config of monitor %s: %v", newSlug, err)
+				}
+				// The monitor of the old slug no longer gets check-ins
+				if oldSlug != newSlug {
+					if err := api.disableMonitor(ctx, oldSlug); err != nil {
+						logger.Warn().Msgf("Cannot disable monitor %s: %v", oldSlug, err)
+					}
+				}
+			}
+		}
+		wasSuspended := oldObj != nil && scheduler.isScheduleSuspended(oldObj)
+		if suspended := scheduler.isScheduleSuspended(obj); suspended != wasSuspended {
+			setCronsMonitorMuted(ctx, cronsMonitorData, suspended)
+		}
+	default:
+		cronsMetaData.addCronsMonitorData(key, updated)
+		// The schedule may have been suspended while the agent was not running
+		if scheduler.isScheduleSuspended(obj) {
+			setCronsMonitorMuted(ctx, updated, true)
+		}
+	}
+}
+
+// Sends the check-ins of a run of a scheduler: at its start, at its end, and
+// when it is deleted before it ends. The ID of the check-in is stored on the
+// object of the run, so that a run that is running when the agent starts is
+// resumed, or checked in as started then if its start was missed.
+func checkinCronsRun(ctx context.Context, scheduler cronsScheduler, obj interface{}, eventHandlerType EventHandlerType) error {
+	run, ok := scheduler.detectRun(ctx, obj)
+	if !ok {
+		return nil
+	}
+	if !shards.ownsObject(run.object.GetNamespace(), run.scheduleUID) {
+		return nil
+	}
+	if run.manual && getManualJobsMode() == ManualJobsIgnore {
+		return nil
+	}
+	cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(run.scheduleKey)
+	if !ok {
+		return errors.New("cannot find the schedule data")
+	}
+
+	runName := run.object.GetName()
+	return withCronsHub(ctx, run.object, func(ctx context.Context) {
+		logger := zerolog.Ctx(ctx)
+		if eventHandlerType == EventHandlerDelete {
+			defer cronsMonitorData.removeJob(runName)
+		}
+		monitorSlug, monitorConfig := cronsMonitorData.getMonitorForRun(run.manual)
+		_, tracked := cronsMonitorData.getJob(runName)
+		annotations := run.object.GetAnnotations()
+
+		switch {
+		case run.result != nil || eventHandlerType == EventHandlerDelete:
+			// The run was already closed before a restart of the agent
+			if annotations[CheckinStatusAnnotation] != "" {
+				return
+			}
+			// The start of the run was not checked in, there is nothing to close
+			checkinID, ok := getRunCheckinID(run, cronsMonitorData)
+			if !ok {
+				return
+			}
+			result := run.result
+			if result == nil {
+				result = scheduler.getDeletedRunResult(run, cronsMonitorData)
+			}
+			if !cronsMonitorData.finishJob(runName, checkinID, result.Status) {
+				return
+			}
+			logger.Debug().Msgf("Checking in at end of %s run %s: status=%s reason=%s retries=%d failed_indexes=%q duration=%s",
+				scheduler.scheduleKind(), runName, result.Status, result.Reason, result.Retries, result.FailedIndexes, result.Duration)
+			captureCronsCheckIn(ctx, &sentry.CheckIn{
+				ID:          checkinID,
+				MonitorSlug: monitorSlug,
+				Status:      result.Status,
+				Duration:    result.Duration,
+			}, monitorConfig)
+			countCronsCheckin(result.Status)
+			if run.result != nil && result.Status == sentry.CheckInStatusError {
+				reportFailedRun(ctx, scheduler, run, cronsMonitorData, checkinID, result)
+			}
+			scheduler.reportRunEnd(ctx, run, cronsMonitorData, checkinID, result)
+
+			if eventHandlerType == EventHandlerDelete {
+				return
+			}
+			err := scheduler.saveRunAnnotations(ctx, run, map[string]string{CheckinStatusAnnotation: string(result.Status)})
+			if err != nil && !apierrors.IsNotFound(err) {
+				logger.Warn().Msgf("Cannot store the check-in status on %s run %s: %v", scheduler.scheduleKind(), runName, err)
+			}
+		case !tracked && annotations[CheckinIDAnnotation] != "":
+			// The agent was restarted since the run started
+			logger.Debug().Msgf("Resuming the check-in of %s run %s", scheduler.scheduleKind(), runName)
+			cronsMonitorData.addRun(runName, run.manual, sentry.EventID(annotations[CheckinIDAnnotation]))
+		case !tracked:
+			logger.Debug().Msgf("Checking in at start of %s run: %s\n", scheduler.scheduleKind(), runName)
+			checkinID := captureCronsCheckIn(ctx, &sentry.CheckIn{
+				MonitorSlug: monitorSlug,
+				Status:      sentry.CheckInStatusInProgress,
+			}, monitorConfig)
+			if checkinID == nil {
+				logger.Error().Msgf("Cannot check in at the start of %s run %s", scheduler.scheduleKind(), runName)
+				return
+			}
+			countCronsCheckin(sentry.CheckInStatusInProgress)
+			cronsMonitorData.addRun(runName, run.manual, *checkinID)
+
+			// The ID is stored on the run, so that it can be closed after a restart
+			err := scheduler.saveRunAnnotations(ctx, run, map[string]string{CheckinIDAnnotation: string(*checkinID)})
+			if err != nil {
+				logger.Warn().Msgf("Cannot store the check-in ID on %s run %s: %v", scheduler.scheduleKind(), runName, err)
+			}
+		}
+	})
+}
+
+// Reports a failed run as an error linked to its monitor, because the
+// check-in only carries the status and the duration of the run
+func reportFailedRun(ctx context.Context, scheduler cronsScheduler, run *cronsRun, cronsMonitorData *CronsMonitorData, checkinID sentry.EventID, result *jobRunResult) {
+	hub := sentry.GetHubFromContext(ctx)
+	if hub == nil {
+		return
+	}
+	monitorSlug, _ := cronsMonitorData.getMonitorForRun(run.manual)
+	runName := run.object.GetName()
+	runContext := sentry.Context{
+		"Name":    runName,
+		"Retries": result.Retries,
+	}
+	if result.Reason != "" {
+		runContext["Reason"] = result.Reason
+	}
+	if result.FailedIndexes != "" {
+		runContext["Failed Indexes"] = result.FailedIndexes
+	}
+	if result.Duration > 0 {
+		runContext["Duration"] = result.Duration.String()
+	}
+
+	message := fmt.Sprintf("%s run %s failed", scheduler.scheduleKind(), runName)
+	if result.Reason != "" {
+		message += ": " + result.Reason
+	}
+	if result.Message != "" {
+		message += " (" + result.Message + ")"
+	}
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelError)
+		scope.SetContext("Monitor", sentry.Context{
+			"Slug":        monitorSlug,
+			"Check-in ID": string(checkinID),
+		})
+		scope.SetContext("Run", runContext)
+		scope.SetFingerprint([]string{"crons-run-failed", monitorSlug, result.Reason})
+		captureCronsMessage(ctx, scope, message)
+	})
+}
+
+// Removes the schedule from the crons registry. The runs that are still
+// in progress are closed, and the monitor is disabled in Sentry.
+func removeCronsMonitor(ctx context.Context, key string, object metav1.Object) {
+	logger := zerolog.Ctx(ctx)
+	cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(key)
+	if !ok {
+		logger.Debug().Msgf("schedule %s not in the crons informer data struct...\n", key)
+		return
+	}
+	cronsMetaData.deleteCronsMonitorData(key)
+	logger.Debug().Msgf("schedule %s deleted from the crons informer data struct...\n", key)
+
+	err := withCronsHub(ctx, object, func(ctx context.Context) {
+		for runName, runData := range cronsMonitorData.getRunningJobs() {
+			if !cronsMonitorData.finishJob(runName, runData.CheckinID, sentry.CheckInStatusError) {
+				continue
+			}
+			logger.Debug().Msgf("Closing the run %s of the deleted schedule %s", runName, key)
+			monitorSlug, monitorConfig := cronsMonitorData.getMonitorForRun(runData.manual)
+			captureCronsCheckIn(ctx, &sentry.CheckIn{ID: runData.CheckinID, MonitorSlug: monitorSlug, Status: sentry.CheckInStatusError}, monitorConfig)
+			countCronsCheckin(sentry.CheckInStatusError)
+		}
+	})
+	if err != nil {
+		logger.Error().Msgf("Cannot close the runs of the deleted schedule %s: %v", key, err)
+	}
+
+	if api := getSentryMonitorAPI(); api != nil {
+		monitorSlug, _ := cronsMonitorData.getMonitor()
+		if err := api.disableMonitor(ctx, monitorSlug); err != nil {
+			logger.Warn().Msgf("Cannot disable monitor %s: %v", monitorSlug, err)
+		}
+	}
+}
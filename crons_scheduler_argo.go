@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const KindArgoCronWorkflow = "CronWorkflow"
+
+// The label that Argo sets on the workflows started by a CronWorkflow
+const argoCronWorkflowLabel = "workflows.argoproj.io/cron-workflow"
+
+var argoCronWorkflowsResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "cronworkflows"}
+var argoWorkflowsResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows"}
+
+func isArgoCronWorkflowsMonitoringEnabled() bool {
+	return isTruthy(os.Getenv("SENTRY_K8S_MONITOR_ARGO_CRONWORKFLOWS"))
+}
+
+// The key of a CronWorkflow in the crons registry, apart from the CronJobs
+func argoCronWorkflowKey(namespace string, name string) string {
+	return KindArgoCronWorkflow + "/" + cronsMonitorKey(namespace, name)
+}
+
+// Argo Workflows CronWorkflows and the Workflows they start, read with the
+// dynamic client because Argo's types are not part of the clientset
+type argoCronWorkflowScheduler struct{}
+
+func (argoCronWorkflowScheduler) scheduleKind() string {
+	return KindArgoCronWorkflow
+}
+
+func (argoCronWorkflowScheduler) discoverSchedule(ctx context.Context, obj interface{}) (string, *CronsMonitorData, bool) {
+	cronWorkflow, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return "", nil, false
+	}
+	key := argoCronWorkflowKey(cronWorkflow.GetNamespace(), cronWorkflow.GetName())
+	if !isScheduleMonitored(ctx, cronWorkflow) {
+		return key, nil, false
+	}
+
+	// Newer versions of Argo accept several schedules, a monitor has one
+	schedule, _, _ := unstructured.NestedString(cronWorkflow.Object, "spec", "schedule")
+	if schedule == "" {
+		schedules, _, _ := unstructured.NestedStringSlice(cronWorkflow.Object, "spec", "schedules")
+		if len(schedules) == 0 {
+			zerolog.Ctx(ctx).Warn().Msgf("CronWorkflow %s/%s has no schedule", cronWorkflow.GetNamespace(), cronWorkflow.GetName())
+			return key, nil, false
+		}
+		schedule = schedules[0]
+	}
+	timezone, _, _ := unstructured.NestedString(cronWorkflow.Object, "spec", "timezone")
+	concurrencyPolicy, _, _ := unstructured.NestedString(cronWorkflow.Object, "spec", "concurrencyPolicy")
+	var startingDeadline *int64
+	if deadline, found, err := unstructured.NestedInt64(cronWorkflow.Object, "spec", "startingDeadlineSeconds"); found && err == nil {
+		startingDeadline = &deadline
+	}
+	var activeDeadline *int64
+	if deadline, found, err := unstructured.NestedInt64(cronWorkflow.Object, "spec", "workflowSpec", "activeDeadlineSeconds"); found && err == nil {
+		activeDeadline = &deadline
+	}
+
+	monitorData := NewCronsMonitorData(getMonitorSlug(cronWorkflow), schedule, nil)
+	monitorData.monitorConfig = newMonitorConfig(ctx, schedule, timezone, startingDeadline, activeDeadline, cronWorkflow.GetAnnotations())
+	monitorData.concurrencyPolicy = batchv1.ConcurrencyPolicy(concurrencyPolicy)
+	parsedSchedule, err := parseSchedule(schedule, timezone)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Msgf("Cannot parse the schedule of CronWorkflow %s/%s: %v", cronWorkflow.GetNamespace(), cronWorkflow.GetName(), err)
+	} else {
+		monitorData.schedule = parsedSchedule
+	}
+	return key, monitorData, true
+}
+
+func (argoCronWorkflowScheduler) isScheduleSuspended(obj interface{}) bool {
+	cronWorkflow, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return false
+	}
+	suspended, _, _ := unstructured.NestedBool(cronWorkflow.Object, "spec", "suspend")
+	return suspended
+}
+
+func (argoCronWorkflowScheduler) detectRun(ctx context.Context, obj interface{}) (*cronsRun, bool) {
+	workflow, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+	cronWorkflowName := workflow.GetLabels()[argoCronWorkflowLabel]
+	if cronWorkflowName == "" {
+		return nil, false
+	}
+	run := &cronsRun{
+		scheduleKey: argoCronWorkflowKey(workflow.GetNamespace(), cronWorkflowName),
+		object:      workflow,
+	}
+	if ref := metav1.GetControllerOf(workflow); ref != nil && ref.Kind == KindArgoCronWorkflow {
+		run.scheduleUID = ref.UID
+	}
+	run.result = getArgoWorkflowRunResult(workflow)
+	return run, true
+}
+
+func (argoCronWorkflowScheduler) saveRunAnnotations(ctx context.Context, run *cronsRun, annotations map[string]string) error {
+	client, err := getDynamicClientFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	patch, err := newAnnotationsPatch(annotations)
+	if err != nil {
+		return err
+	}
+	_, err = client.Resource(argoWorkflowsResource).Namespace(run.object.GetNamespace()).Patch(
+		ctx, run.object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{},
+	)
+	return err
+}
+
+func (argoCronWorkflowScheduler) getDeletedRunResult(run *cronsRun, _ *CronsMonitorData) *jobRunResult {
+	result := &jobRunResult{Status: sentry.CheckInStatusError, Reason: "Deleted"}
+	workflow := run.object.(*unstructured.Unstructured)
+	startedAt, _, _ := unstructured.NestedString(workflow.Object, "status", "startedAt")
+	if start, err := time.Parse(time.RFC3339, startedAt); err == nil {
+		result.Duration = time.Since(start)
+	}
+	return result
+}
+
+func (argoCronWorkflowScheduler) reportRunEnd(context.Context, *cronsRun, *CronsMonitorData, sentry.EventID, *jobRunResult) {
+}
+
+// Returns the outcome of a workflow from its phase, nil while it is pending or running
+func getArgoWorkflowRunResult(workflow *unstructured.Unstructured) *jobRunResult {
+	phase, _, _ := unstructured.NestedString(workflow.Object, "status", "phase")
+	result := &jobRunResult{Reason: phase}
+	switch phase {
+	case "Succeeded":
+		result.Status = sentry.CheckInStatusOK
+	case "Failed", "Error":
+		result.Status = sentry.CheckInStatusError
+	default:
+		return nil
+	}
+	result.Message, _, _ = unstructured.NestedString(workflow.Object, "status", "message")
+
+	startedAt, _, _ := unstructured.NestedString(workflow.Object, "status", "startedAt")
+	finishedAt, _, _ := unstructured.NestedString(workflow.Object, "status", "finishedAt")
+	start, startErr := time.Parse(time.RFC3339, startedAt)
+	end, endErr := time.Parse(time.RFC3339, finishedAt)
+	if startErr == nil && endErr == nil && end.After(start) {
+		result.Duration = end.Sub(start)
+	}
+	return result
+}
+
+// Creates the informers of the CronWorkflows and of the Workflows they
+// start (selected by label), with the crons handlers
+func createArgoCronWorkflowInformers(ctx context.Context, client dynamic.Interface, namespace string) []cache.SharedIndexInformer {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msgf("Add CronWorkflow informer handlers for Argo Workflows monitoring")
+
+	cronWorkflowInformer := dynamicinformer.NewFilteredDynamicInformer(
+		client, argoCronWorkflowsResource, namespace, 5*time.Second, cache.Indexers{}, nil,
+	).Informer()
+	workflowInformer := dynamicinformer.NewFilteredDynamicInformer(
+		client, argoWorkflowsResource, namespace, 5*time.Second, cache.Indexers{},
+		func(options *metav1.ListOptions) {
+			options.LabelSelector = argoCronWorkflowLabel
+		},
+	).Informer()
+	addCronsSchedulerHandlers(ctx, argoCronWorkflowScheduler{}, cronWorkflowInformer, workflowInformer)
+	return []cache.SharedIndexInformer{cronWorkflowInformer, workflowInformer}
+}
+
+// Starts the Argo informers and waits until they are synced. The runs are
+// then reconciled: a workflow may have been seen before its CronWorkflow.
+func startArgoCronWorkflowInformers(ctx context.Context, namespace string, stopChan <-chan struct{}) error {
+	client, err := getDynamicClientFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	argoInformers := createArgoCronWorkflowInformers(ctx, client, namespace)
+	for _, informer := range argoInformers {
+		go informer.Run(stopChan)
+	}
+	for _, informer := range argoInformers {
+		if ok := cache.WaitForCacheSync(stopChan, informer.HasSynced); !ok {
+			return errors.New("argo informer failed to sync")
+		}
+	}
+
+	workflowInformer := argoInformers[1]
+	reconcileCronsRuns(ctx, argoCronWorkflowScheduler{}, workflowInformer.GetStore().List())
+	return nil
+}
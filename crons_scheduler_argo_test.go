@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newArgoTestCronWorkflow() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       KindArgoCronWorkflow,
+		"metadata": map[string]interface{}{
+			"name":      "nightly-etl",
+			"namespace": "data",
+			"uid":       "nightly-etl-uid",
+		},
+		"spec": map[string]interface{}{
+			"schedule":                "0 2 * * *",
+			"timezone":                "Europe/Vienna",
+			"startingDeadlineSeconds": int64(90),
+			"concurrencyPolicy":       "Forbid",
+		},
+	}}
+}
+
+func newArgoTestWorkflow(name string, phase string) *unstructured.Unstructured {
+	workflow := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Workflow",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "data",
+			"labels":    map[string]interface{}{argoCronWorkflowLabel: "nightly-etl"},
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind":       KindArgoCronWorkflow,
+				"name":       "nightly-etl",
+				"uid":        "nightly-etl-uid",
+				"controller": true,
+			}},
+		},
+	}}
+	if phase != "" {
+		workflow.Object["status"] = map[string]interface{}{
+			"phase":      phase,
+			"startedAt":  "2024-03-01T02:00:00Z",
+			"finishedAt": "2024-03-01T02:03:00Z",
+		}
+	}
+	return workflow
+}
+
+func TestArgoCronWorkflowDiscoverSchedule(t *testing.T) {
+	ctx, _, _ := newCronsTestContext(t, newCronsTestCronJob())
+	key, cronsMonitorData, ok := argoCronWorkflowScheduler{}.discoverSchedule(ctx, newArgoTestCronWorkflow())
+	if !ok || key != "CronWorkflow/data/nightly-etl" {
+		t.Fatalf("The CronWorkflow should be monitored, key: %q", key)
+	}
+	monitorSlug, monitorConfig := cronsMonitorData.getMonitor()
+	if monitorSlug != "nightly-etl" {
+		t.Errorf("Monitor slug expected: nightly-etl, actual: %s", monitorSlug)
+	}
+	if monitorConfig.Schedule != sentry.CrontabSchedule("0 2 * * *") || monitorConfig.Timezone != "Europe/Vienna" || monitorConfig.CheckInMargin != 2 {
+		t.Errorf("Unexpected monitor config: %#v", monitorConfig)
+	}
+	if schedule, concurrencyPolicy := cronsMonitorData.getSchedule(); schedule == nil || concurrencyPolicy != "Forbid" {
+		t.Errorf("Unexpected schedule: %v, %s", schedule, concurrencyPolicy)
+	}
+
+	// Workflows that were not started by a CronWorkflow are not runs
+	workflow := newArgoTestWorkflow("adhoc", "")
+	workflow.SetLabels(nil)
+	if _, ok := (argoCronWorkflowScheduler{}).detectRun(ctx, workflow); ok {
+		t.Errorf("A workflow without the CronWorkflow label should not be a run")
+	}
+}
+
+func TestArgoCronWorkflowCheckins(t *testing.T) {
+	ctx, transport, _ := newCronsTestContext(t, newCronsTestCronJob())
+	scheduler := argoCronWorkflowScheduler{}
+	syncCronsSchedule(ctx, scheduler, nil, newArgoTestCronWorkflow())
+
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-1", ""), EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-1", "Running"), EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-1", "Failed"), EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+	// Updates after the end are not checked in again
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-1", "Failed"), EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+
+	events := transport.Events()
+	if len(events) != 3 {
+		t.Fatalf("Events expected: 2 check-ins and the error of the failed run, actual: %#v", events)
+	}
+	if events[0].CheckIn.MonitorSlug != "nightly-etl" || events[0].CheckIn.Status != sentry.CheckInStatusInProgress {
+		t.Errorf("An in-progress check-in expected: %#v", events[0].CheckIn)
+	}
+	if events[1].CheckIn.ID != events[0].CheckIn.ID || events[1].CheckIn.Status != sentry.CheckInStatusError || events[1].CheckIn.Duration != 3*time.Minute {
+		t.Errorf("An error check-in of 3 minutes expected: %#v", events[1].CheckIn)
+	}
+	if events[2].Level != sentry.LevelError || events[2].Message != "CronWorkflow run nightly-etl-1 failed: Failed" {
+		t.Errorf("An error about the failed run expected: %#v", events[2])
+	}
+
+	// A run that is deleted before it ends is closed
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-2", "Running"), EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkinCronsRun(ctx, scheduler, newArgoTestWorkflow("nightly-etl-2", "Running"), EventHandlerDelete); err != nil {
+		t.Fatal(err)
+	}
+	events = transport.Events()
+	if len(events) != 5 || events[4].CheckIn.ID != events[3].CheckIn.ID || events[4].CheckIn.Status != sentry.CheckInStatusError {
+		t.Errorf("The deleted run should be closed with an error check-in: %#v", events)
+	}
+
+	// The schedule is no longer monitored once it is opted out
+	optedOut := newArgoTestCronWorkflow()
+	optedOut.SetAnnotations(map[string]string{MonitorAnnotation: "false"})
+	syncCronsSchedule(ctx, scheduler, nil, optedOut)
+	if _, ok := cronsMetaData.getCronsMonitorData("CronWorkflow/data/nightly-etl"); ok {
+		t.Errorf("The opted out CronWorkflow should be removed from the crons registry")
+	}
+}
+
+func TestArgoCronWorkflowResumesCheckinAfterRestart(t *testing.T) {
+	ctx, transport, _ := newCronsTestContext(t, newCronsTestCronJob())
+	workflow := newArgoTestWorkflow("nightly-etl-1", "Running")
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), workflow.DeepCopy())
+	ctx = setDynamicClientOnContext(ctx, dynamicClient)
+	scheduler := argoCronWorkflowScheduler{}
+	syncCronsSchedule(ctx, scheduler, nil, newArgoTestCronWorkflow())
+
+	if err := checkinCronsRun(ctx, scheduler, workflow, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	events := transport.Events()
+	if len(events) != 1 || events[0].CheckIn.Status != sentry.CheckInStatusInProgress {
+		t.Fatalf("An in-progress check-in expected, actual: %#v", events)
+	}
+	checkinID := events[0].CheckIn.ID
+
+	storedWorkflow, err := dynamicClient.Resource(argoWorkflowsResource).Namespace("data").Get(ctx, "nightly-etl-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedWorkflow.GetAnnotations()[CheckinIDAnnotation] != string(checkinID) {
+		t.Errorf("Check-in ID annotation expected: %s, actual: %q", checkinID, storedWorkflow.GetAnnotations()[CheckinIDAnnotation])
+	}
+
+	// The agent restarts while the workflow is running: the check-in is resumed
+	cronsMetaData = NewCronsMetaData()
+	syncCronsSchedule(ctx, scheduler, nil, newArgoTestCronWorkflow())
+	reconcileCronsRuns(ctx, scheduler, []interface{}{storedWorkflow})
+	if events = transport.Events(); len(events) != 1 {
+		t.Fatalf("No new check-in expected, actual: %#v", events)
+	}
+
+	// The workflow ends
+	unstructured.SetNestedField(storedWorkflow.Object, "Succeeded", "status", "phase")
+	if err := checkinCronsRun(ctx, scheduler, storedWorkflow, EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+	events = transport.Events()
+	if len(events) != 2 || events[1].CheckIn.ID != checkinID || events[1].CheckIn.Status != sentry.CheckInStatusOK {
+		t.Fatalf("The ending check-in expected with ID %s: %#v", checkinID, events)
+	}
+	storedWorkflow, err = dynamicClient.Resource(argoWorkflowsResource).Namespace("data").Get(ctx, "nightly-etl-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storedWorkflow.GetAnnotations()[CheckinStatusAnnotation] != string(sentry.CheckInStatusOK) {
+		t.Errorf("Check-in status annotation expected: ok, actual: %q", storedWorkflow.GetAnnotations()[CheckinStatusAnnotation])
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Enables ("true") or disables ("false") the Crons monitoring of a CronJob,
+// or of all the CronJobs of a Namespace
+const MonitorAnnotation = "k8s.sentry.io/monitor"
+
+// Selects the CronJobs that are monitored with Sentry Crons, in the "crons"
+// section of the configuration file. The annotations take precedence over it.
+type CronsConfig struct {
+	// Label selectors evaluated against the CronJob (or the other schedule
+	// object, e.g. an Argo CronWorkflow) and its Namespace
+	CronJobSelector   string `json:"cronJobSelector,omitempty"`
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Whether the CronJobs without annotations are monitored when no selector
+	// is set. Defaults to true.
+	MonitorByDefault *bool `json:"monitorByDefault,omitempty"`
+
+	cronJobSelector   labels.Selector
+	namespaceSelector labels.Selector
+}
+
+func prepareCronsConfig() error {
+	config := agentConfig.Crons
+	if config == nil {
+		return nil
+	}
+	var err error
+	if config.CronJobSelector != "" {
+		if config.cronJobSelector, err = labels.Parse(config.CronJobSelector); err != nil {
+			return fmt.Errorf("crons: invalid cronJobSelector: %v", err)
+		}
+	}
+	if config.NamespaceSelector != "" {
+		if config.namespaceSelector, err = labels.Parse(config.NamespaceSelector); err != nil {
+			return fmt.Errorf("crons: invalid namespaceSelector: %v", err)
+		}
+	}
+	return nil
+}
+
+// Parses the monitor annotation, ok is false if it is not set (or invalid)
+func getMonitorAnnotation(annotations map[string]string) (monitored bool, ok bool) {
+	value, found := annotations[MonitorAnnotation]
+	if !found {
+		return false, false
+	}
+	monitored, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, false
+	}
+	return monitored, true
+}
+
+// Returns whether the CronJob (or another schedule object) is monitored with
+// Sentry Crons: the annotation of the object, then the annotation of its
+// Namespace, then the selectors of the configuration file decide
+func isScheduleMonitored(ctx context.Context, object metav1.Object) bool {
+	if monitored, ok := getMonitorAnnotation(object.GetAnnotations()); ok {
+		return monitored
+	}
+	namespaceObj, namespaceFound := findClusterObjectCached(ctx, KindNamespace, object.GetNamespace())
+	if namespaceFound {
+		if monitored, ok := getMonitorAnnotation(namespaceObj.GetAnnotations()); ok {
+			return monitored
+		}
+	}
+
+	config := agentConfig.Crons
+	if config == nil {
+		return true
+	}
+	if config.cronJobSelector == nil && config.namespaceSelector == nil {
+		return config.MonitorByDefault == nil || *config.MonitorByDefault
+	}
+	if config.cronJobSelector != nil && !config.cronJobSelector.Matches(labels.Set(object.GetLabels())) {
+		return false
+	}
+	if config.namespaceSelector != nil && (!namespaceFound || !config.namespaceSelector.Matches(labels.Set(namespaceObj.GetLabels()))) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIsScheduleMonitored(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+
+	namespaces := []*corev1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "crons-plain", Labels: map[string]string{"team": "data"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "crons-opted-out", Annotations: map[string]string{MonitorAnnotation: "false"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "crons-opted-in", Annotations: map[string]string{MonitorAnnotation: "true"}}},
+	}
+	fakeClientset := fake.NewSimpleClientset()
+	for _, namespace := range namespaces {
+		if _, err := fakeClientset.CoreV1().Namespaces().Create(context.Background(), namespace, metav1.CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	newCronJob := func(namespace string, labels map[string]string, annotations map[string]string) *batchv1.CronJob {
+		return &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{
+			Name:        "backup",
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		}}
+	}
+	monitorByDefault := false
+	testCases := []struct {
+		name     string
+		config   *CronsConfig
+		cronjob  *batchv1.CronJob
+		expected bool
+	}{
+		{"monitored by default", nil, newCronJob("crons-plain", nil, nil), true},
+		{"cronjob opted out", nil, newCronJob("crons-plain", nil, map[string]string{MonitorAnnotation: "false"}), false},
+		{"namespace opted out", nil, newCronJob("crons-opted-out", nil, nil), false},
+		{"cronjob opted in, namespace opted out", nil, newCronJob("crons-opted-out", nil, map[string]string{MonitorAnnotation: "true"}), true},
+		{"not monitored by default", &CronsConfig{MonitorByDefault: &monitorByDefault}, newCronJob("crons-plain", nil, nil), false},
+		{"namespace opted in", &CronsConfig{MonitorByDefault: &monitorByDefault}, newCronJob("crons-opted-in", nil, nil), true},
+		{"cronjob selector matches", &CronsConfig{CronJobSelector: "crons=enabled"}, newCronJob("crons-plain", map[string]string{"crons": "enabled"}, nil), true},
+		{"cronjob selector does not match", &CronsConfig{CronJobSelector: "crons=enabled"}, newCronJob("crons-plain", nil, nil), false},
+		{"namespace selector matches", &CronsConfig{NamespaceSelector: "team=data"}, newCronJob("crons-plain", nil, nil), true},
+		{"namespace selector does not match", &CronsConfig{NamespaceSelector: "team=web"}, newCronJob("crons-plain", nil, nil), false},
+		{"annotation overrides selector", &CronsConfig{NamespaceSelector: "team=web"}, newCronJob("crons-plain", nil, map[string]string{MonitorAnnotation: "true"}), true},
+	}
+
+	for _, testCase := range testCases {
+		agentConfig = &AgentConfig{Crons: testCase.config}
+		if err := prepareCronsConfig(); err != nil {
+			t.Fatalf("%s: %v", testCase.name, err)
+		}
+		if monitored := isScheduleMonitored(ctx, testCase.cronjob); monitored != testCase.expected {
+			t.Errorf("%s: monitored expected: %v, actual: %v", testCase.name, testCase.expected, monitored)
+		}
+	}
+}
+
+func TestCheckinHonorsNamespaceOptOut(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	cronjob.Namespace = "crons-checkin-opted-out"
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "crons-checkin-opted-out",
+		Annotations: map[string]string{MonitorAnnotation: "false"},
+	}}
+	job := newCronsTestJob(cronjob, "backup-1")
+	ctx, transport, _ := newCronsTestContext(t, cronjob, cronjob, namespace)
+
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	if events := transport.Events(); len(events) != 0 {
+		t.Errorf("The CronJob of an opted out namespace should not be checked in: %#v", events)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Overrides the monitor slug of a CronJob
+const MonitorSlugAnnotation = "k8s.sentry.io/monitor-slug"
+
+// The slug used to be the bare CronJob name, which is kept as the default
+const defaultMonitorSlugTemplate = "{{name}}"
+
+// Sentry limits monitor slugs to 50 characters
+const maxMonitorSlugLength = 50
+
+// Returns the template of monitor slugs, with the {{cluster}},
+// {{namespace}} and {{name}} placeholders
+func getMonitorSlugTemplate() string {
+	template := strings.TrimSpace(os.Getenv("SENTRY_K8S_MONITOR_SLUG_TEMPLATE"))
+	if template == "" {
+		return defaultMonitorSlugTemplate
+	}
+	return template
+}
+
+// Returns the name of the cluster: SENTRY_K8S_CLUSTER_NAME,
+// or the name found by the GKE integration
+func getClusterName() string {
+	if clusterName := strings.TrimSpace(os.Getenv("SENTRY_K8S_CLUSTER_NAME")); clusterName != "" {
+		return clusterName
+	}
+	if gke := GetIntegrationGKE(); gke.IsInitialized() {
+		return gke.clusterName
+	}
+	return ""
+}
+
+// The key of a CronJob in the crons registry
+func cronsMonitorKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// Returns the monitor slug of the CronJob (or of another schedule object):
+// the value of the monitor slug annotation, or the slug template filled in
+func getMonitorSlug(object metav1.Object) string {
+	if slug := sanitizeMonitorSlug(object.GetAnnotations()[MonitorSlugAnnotation]); slug != "" {
+		return slug
+	}
+	replacer := strings.NewReplacer(
+		"{{cluster}}", getClusterName(),
+		"{{namespace}}", object.GetNamespace(),
+		"{{name}}", object.GetName(),
+	)
+	return sanitizeMonitorSlug(replacer.Replace(getMonitorSlugTemplate()))
+}
+
+// Turns the value into a valid monitor slug: lowercase letters, digits,
+// underscores and hyphens, without leading or trailing separators. Slugs that
+// are too long are shortened, with a hash of the full slug to keep them unique.
+func sanitizeMonitorSlug(value string) string {
+	var builder strings.Builder
+	lastHyphen := false
+	for _, char := range strings.ToLower(value) {
+		switch {
+		case (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '_':
+			builder.WriteRune(char)
+			lastHyphen = false
+		case !lastHyphen:
+			builder.WriteRune('-')
+			lastHyphen = true
+		}
+	}
+	slug := strings.Trim(builder.String(), "-_")
+	if len(slug) <= maxMonitorSlugLength {
+		return slug
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(slug))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return strings.TrimRight(slug[:maxMonitorSlugLength-len(suffix)], "-_") + suffix
+}
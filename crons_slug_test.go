@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSanitizeMonitorSlug(t *testing.T) {
+	testCases := map[string]string{
+		"backup":                  "backup",
+		"Nightly Backup":          "nightly-backup",
+		"prod/default/backup":     "prod-default-backup",
+		"--weird..name__":         "weird-name",
+		"data_team.etl-job":       "data_team-etl-job",
+		"":                        "",
+		"{{cluster}}-ns-backup":   "cluster-ns-backup",
+		"-default-cronjob-basic-": "default-cronjob-basic",
+	}
+	for value, expected := range testCases {
+		if slug := sanitizeMonitorSlug(value); slug != expected {
+			t.Errorf("Slug of %q expected: %q, actual: %q", value, expected, slug)
+		}
+	}
+
+	// Long slugs are shortened, and stay unique
+	first := sanitizeMonitorSlug("production-cluster-" + strings.Repeat("a", 40) + "-backup-1")
+	second := sanitizeMonitorSlug("production-cluster-" + strings.Repeat("a", 40) + "-backup-2")
+	if len(first) > maxMonitorSlugLength || len(second) > maxMonitorSlugLength {
+		t.Errorf("Slugs are too long: %q, %q", first, second)
+	}
+	if first == second {
+		t.Errorf("Shortened slugs collide: %q", first)
+	}
+}
+
+func TestGetMonitorSlug(t *testing.T) {
+	newCronJob := func(namespace string, annotations map[string]string) *batchv1.CronJob {
+		return &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "backup",
+				Namespace:   namespace,
+				Annotations: annotations,
+			},
+		}
+	}
+
+	// The default slug is the name of the CronJob
+	if slug := getMonitorSlug(newCronJob("team-a", nil)); slug != "backup" {
+		t.Errorf("Default slug expected: backup, actual: %q", slug)
+	}
+
+	t.Setenv("SENTRY_K8S_MONITOR_SLUG_TEMPLATE", "{{cluster}}-{{namespace}}-{{name}}")
+	t.Setenv("SENTRY_K8S_CLUSTER_NAME", "Prod EU")
+	slugA := getMonitorSlug(newCronJob("team-a", nil))
+	slugB := getMonitorSlug(newCronJob("team-b", nil))
+	if slugA != "prod-eu-team-a-backup" || slugB != "prod-eu-team-b-backup" {
+		t.Errorf("Unexpected templated slugs: %q, %q", slugA, slugB)
+	}
+
+	annotated := newCronJob("team-a", map[string]string{MonitorSlugAnnotation: "Team A Backup"})
+	if slug := getMonitorSlug(annotated); slug != "team-a-backup" {
+		t.Errorf("Annotated slug expected: team-a-backup, actual: %q", slug)
+	}
+}
+
+func TestCronsMonitorKeyByNamespace(t *testing.T) {
+	cronsMetaData := NewCronsMetaData()
+	var completions int32 = 1
+	cronsMetaData.addCronsMonitorData(cronsMonitorKey("team-a", "backup"), NewCronsMonitorData("team-a-backup", "* * * * *", &completions))
+	cronsMetaData.addCronsMonitorData(cronsMonitorKey("team-b", "backup"), NewCronsMonitorData("team-b-backup", "* * * * *", &completions))
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		monitorData, ok := cronsMetaData.getCronsMonitorData(cronsMonitorKey(namespace, "backup"))
+		if !ok || monitorData.MonitorSlug != namespace+"-backup" {
+			t.Errorf("Unexpected monitor data for namespace %s: %#v", namespace, monitorData)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckinJobReplaced(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	cronjob.Spec.ConcurrencyPolicy = batchv1.ReplaceConcurrent
+	job := newCronsTestJob(cronjob, "backup-1")
+	job.Status.Active = 1
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CronJob controller deletes the running job when the next run starts
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerDelete); err != nil {
+		t.Fatal(err)
+	}
+	events := transport.Events()
+	if len(events) != 3 {
+		t.Fatalf("Events expected: 3, actual: %#v", events)
+	}
+	if events[1].CheckIn == nil || events[1].CheckIn.ID != events[0].CheckIn.ID || events[1].CheckIn.Status != sentry.CheckInStatusError {
+		t.Errorf("The replaced job should be closed with an error check-in: %#v", events[1].CheckIn)
+	}
+	if events[2].Level != sentry.LevelWarning || !strings.Contains(events[2].Message, "concurrencyPolicy: Replace") {
+		t.Errorf("A warning about the replaced job expected: %q", events[2].Message)
+	}
+	if monitor := events[2].Contexts["Monitor"]; monitor["Check-in ID"] != string(events[0].CheckIn.ID) {
+		t.Errorf("The warning should be linked to the check-in: %#v", monitor)
+	}
+}
+
+func TestCheckinJobForbidSkippedRuns(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	cronjob.Spec.ConcurrencyPolicy = batchv1.ForbidConcurrent
+	job := newCronsTestJob(cronjob, "backup-1")
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job runs for 12 minutes with a schedule of every 5 minutes
+	startTime := metav1.NewTime(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+	completionTime := metav1.NewTime(startTime.Add(12 * time.Minute))
+	job.Status = batchv1.JobStatus{Succeeded: 1, StartTime: &startTime, CompletionTime: &completionTime, Conditions: []batchv1.JobCondition{
+		{Type: batchv1.JobComplete, Status: v1.ConditionTrue},
+	}}
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerUpdate); err != nil {
+		t.Fatal(err)
+	}
+
+	events := transport.Events()
+	if len(events) != 3 || events[1].CheckIn.Status != sentry.CheckInStatusOK {
+		t.Fatalf("Events expected: 3, actual: %#v", events)
+	}
+	if !strings.HasPrefix(events[2].Message, "2 scheduled run(s) of CronJob backup were not started on time") {
+		t.Errorf("A warning about the skipped runs expected: %q", events[2].Message)
+	}
+}
+
+func TestCheckinManualJobs(t *testing.T) {
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-manual-x7k2p")
+	job.Annotations = map[string]string{manualJobAnnotation: "manual"}
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+
+	// Manual runs are ignored by default
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	if events := transport.Events(); len(events) != 0 {
+		t.Fatalf("Manual runs should be ignored: %#v", events)
+	}
+
+	t.Setenv("SENTRY_K8S_MONITOR_MANUAL_JOBS", "separate")
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	events := transport.Events()
+	if len(events) != 1 || events[0].CheckIn.MonitorSlug != "backup-manual" || events[0].MonitorConfig != nil {
+		t.Fatalf("A check-in to the separate monitor expected: %#v", events)
+	}
+
+	// The job is no longer tracked once it is deleted
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerDelete); err != nil {
+		t.Fatal(err)
+	}
+	cronsMonitorData, _ := cronsMetaData.getCronsMonitorData(cronsMonitorKey("default", "backup"))
+	if _, ok := cronsMonitorData.getJob(job.Name); ok {
+		t.Errorf("The deleted job should not be tracked")
+	}
+	events = transport.Events()
+	if len(events) != 2 || events[1].CheckIn.MonitorSlug != "backup-manual" || events[1].CheckIn.Status != sentry.CheckInStatusError {
+		t.Errorf("The deleted run should be closed on the separate monitor: %#v", events)
+	}
+}
+
+func TestCheckinJobDestinations(t *testing.T) {
+	// The pooled clients are bound to the transport of the test
+	oldDsnClientMapping := dsnClientMapping
+	defer func() { dsnClientMapping = oldDsnClientMapping }()
+	dsnClientMapping = NewDsnClientMapping()
+	dsnClientMapping.customDsnFlag = true
+
+	cronjob := newCronsTestCronJob()
+	cronjob.Spec.ConcurrencyPolicy = batchv1.ReplaceConcurrent
+	job := newCronsTestJob(cronjob, "backup-1")
+	job.Status.Active = 1
+	job.Annotations = map[string]string{DSNAnnotation: "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/2345678901," +
+		"https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/2345678902"}
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	setMainTestClient(t, sentry.GetHubFromContext(ctx).Client())
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerDelete); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every DSN of the annotation gets the check-ins, with the same ID, and the warning
+	events := transport.Events()
+	if len(events) != 6 {
+		t.Fatalf("Events expected: 6, actual: %#v", events)
+	}
+	checkinID := events[0].CheckIn.ID
+	for i, status := range []sentry.CheckInStatus{sentry.CheckInStatusInProgress, sentry.CheckInStatusInProgress, sentry.CheckInStatusError, sentry.CheckInStatusError} {
+		if events[i].CheckIn == nil || events[i].CheckIn.ID != checkinID || events[i].CheckIn.Status != status {
+			t.Errorf("Check-in #%d expected: %s with ID %s, actual: %#v", i, status, checkinID, events[i].CheckIn)
+		}
+	}
+	if !strings.Contains(events[4].Message, "concurrencyPolicy: Replace") || events[5].Message != events[4].Message {
+		t.Errorf("The warning should be sent to both DSNs: %q, %q", events[4].Message, events[5].Message)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	TagsAnnotation        = "k8s.sentry.io/tags"
+	EnvironmentAnnotation = "k8s.sentry.io/environment"
+)
+
+// Mapping from object label/annotation keys to Sentry tag names
+type TagMappings struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Parses the value of the tags annotation: "key1=value1,key2=value2"
+func parseTagsAnnotation(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		keyValue := strings.SplitN(pair, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])
+		if key != "" && value != "" {
+			tags[key] = value
+		}
+	}
+	return tags
+}
+
+// Sets the tags of the mapped keys of the object's labels or annotations,
+// in the order of the keys, so a later key overrides an earlier one that is
+// mapped to the same tag
+func applyTagMapping(objTags map[string]string, mapping map[string]string, values map[string]string) {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if tagKey, value := mapping[key], values[key]; tagKey != "" && value != "" {
+			objTags[tagKey] = value
+		}
+	}
+}
+
+// Collects custom tags and environment from the object and its owners.
+// For every tag, the value from the nearest object in the owner chain wins.
+// On the same object, the annotation mappings override the label mappings,
+// and the tags annotation overrides both.
+func collectCustomTags(ctx context.Context, object metav1.Object) (tags map[string]string, environment string) {
+	logger := zerolog.Ctx(ctx)
+	mappings := agentConfig.TagMappings
+	tags = make(map[string]string)
+
+	err := walkOwnerChain(ctx, object, func(obj metav1.Object) bool {
+		objAnnotations := obj.GetAnnotations()
+		objTags := make(map[string]string)
+		applyTagMapping(objTags, mappings.Labels, obj.GetLabels())
+		applyTagMapping(objTags, mappings.Annotations, objAnnotations)
+		for tagKey, tagValue := range parseTagsAnnotation(objAnnotations[TagsAnnotation]) {
+			objTags[tagKey] = tagValue
+		}
+		for tagKey, tagValue := range objTags {
+			if _, ok := tags[tagKey]; !ok {
+				tags[tagKey] = tagValue
+			}
+		}
+
+		if environment == "" {
+			environment = strings.TrimSpace(objAnnotations[EnvironmentAnnotation])
+		}
+		return true
+	})
+	if err != nil {
+		logger.Debug().Msgf("Cannot collect custom tags from all owners: %v", err)
+	}
+	return tags, environment
+}
+
+// Sets the custom tags and environment derived from the object on the scope and the event
+func applyCustomTags(ctx context.Context, scope *sentry.Scope, sentryEvent *sentry.Event, object metav1.Object) {
+	if object == nil {
+		return
+	}
+	tags, environment := collectCustomTags(ctx, object)
+	for key, value := range tags {
+		setTagIfNotEmpty(scope, key, value)
+	}
+	if environment != "" {
+		sentryEvent.Environment = environment
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseTagsAnnotation(t *testing.T) {
+	tags := parseTagsAnnotation("team=payments, tier = backend,invalid,empty=")
+	expected := map[string]string{
+		"team": "payments",
+		"tier": "backend",
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Tags expected: %v, actual: %v", expected, tags)
+	}
+}
+
+func TestCollectCustomTags(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = &AgentConfig{
+		TagMappings: TagMappings{
+			Labels:      map[string]string{"app.kubernetes.io/name": "app"},
+			Annotations: map[string]string{"team": "owner"},
+		},
+	}
+
+	deploymentObj := &v1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestCollectCustomTagsDeployment",
+			Namespace: "TestCollectCustomTagsNamespace",
+			Labels:    map[string]string{"app.kubernetes.io/name": "checkout"},
+			Annotations: map[string]string{
+				"team":                "payments",
+				TagsAnnotation:        "tier=backend",
+				EnvironmentAnnotation: "staging",
+			},
+		},
+	}
+	replicasetObj := &v1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestCollectCustomTagsReplicaset",
+			Namespace:   "TestCollectCustomTagsNamespace",
+			Annotations: map[string]string{TagsAnnotation: "tier=frontend"},
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "Deployment",
+					Name: "TestCollectCustomTagsDeployment",
+				},
+			},
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestCollectCustomTagsPod",
+			Namespace: "TestCollectCustomTagsNamespace",
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "ReplicaSet",
+					Name: "TestCollectCustomTagsReplicaset",
+				},
+			},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(deploymentObj, replicasetObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	tags, environment := collectCustomTags(ctx, podObj)
+	expectedTags := map[string]string{
+		"app":   "checkout",
+		"owner": "payments",
+		// The replicaset is closer to the pod than the deployment
+		"tier": "frontend",
+	}
+	if !reflect.DeepEqual(tags, expectedTags) {
+		t.Errorf("Tags expected: %v, actual: %v", expectedTags, tags)
+	}
+	if environment != "staging" {
+		t.Errorf("Environment expected: %s, actual: %s", "staging", environment)
+	}
+}
+
+func TestCollectCustomTagsPrecedence(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = &AgentConfig{
+		TagMappings: TagMappings{
+			Labels:      map[string]string{"team": "owner", "app.kubernetes.io/name": "app"},
+			Annotations: map[string]string{"owner": "owner", "contact": "owner"},
+		},
+	}
+
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestCollectCustomTagsPrecedencePod",
+			Namespace:   "TestCollectCustomTagsPrecedenceNamespace",
+			Labels:      map[string]string{"team": "label-team", "app.kubernetes.io/name": "checkout"},
+			Annotations: map[string]string{"owner": "annotation-owner", "contact": "annotation-contact"},
+		},
+	}
+	ctx := setClientsetOnContext(context.Background(), fake.NewSimpleClientset(podObj))
+
+	// The same tag wins on every event: annotations override labels, and
+	// the later of the annotation keys mapped to the same tag wins
+	for i := 0; i < 20; i++ {
+		tags, _ := collectCustomTags(ctx, podObj)
+		if tags["owner"] != "annotation-owner" || tags["app"] != "checkout" {
+			t.Fatalf("Unexpected tags: %v", tags)
+		}
+	}
+
+	podObj.Annotations[TagsAnnotation] = "owner=tags-annotation"
+	if tags, _ := collectCustomTags(ctx, podObj); tags["owner"] != "tags-annotation" {
+		t.Errorf("The tags annotation should override the mappings: %v", tags)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	globalLogger "github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// A platform destination receives a copy of every matching event, regardless
+// of the project the event is routed to. The filters work like the matchers
+// of sampling rules; a destination without filters receives all events.
+type PlatformDestination struct {
+	Dsn          string   `json:"dsn"`
+	Reasons      []string `json:"reasons,omitempty"`
+	Namespaces   []string `json:"namespaces,omitempty"`
+	EventSources []string `json:"eventSources,omitempty"`
+	Watchers     []string `json:"watchers,omitempty"`
+	Levels       []string `json:"levels,omitempty"`
+
+	levels []sentry.Level
+}
+
+func preparePlatformDestinations() error {
+	for i, destination := range agentConfig.PlatformDsns {
+		if strings.TrimSpace(destination.Dsn) == "" {
+			return fmt.Errorf("platform destination #%d: dsn is required", i)
+		}
+		if _, err := sentry.NewDsn(destination.Dsn); err != nil {
+			return fmt.Errorf("platform destination #%d: invalid dsn: %v", i, err)
+		}
+		destination.levels = nil
+		for _, rawLevel := range destination.Levels {
+			level, ok := annotationLevels[strings.ToLower(strings.TrimSpace(rawLevel))]
+			if !ok {
+				return fmt.Errorf("platform destination #%d: unknown level %q", i, rawLevel)
+			}
+			destination.levels = append(destination.levels, level)
+		}
+	}
+	globalLogger.Debug().Msgf("Prepared %d platform destination(s)", len(agentConfig.PlatformDsns))
+	return nil
+}
+
+func (d *PlatformDestination) matches(params *samplingParams, sentryEvent *sentry.Event) bool {
+	if len(d.Reasons) > 0 && !containsFold(d.Reasons, params.reason) {
+		return false
+	}
+	if len(d.Namespaces) > 0 && !matchesAnyPattern(d.Namespaces, params.namespace) {
+		return false
+	}
+	if len(d.EventSources) > 0 && !containsFold(d.EventSources, params.eventSource) {
+		return false
+	}
+	if len(d.Watchers) > 0 && !containsFold(d.Watchers, params.watcher) {
+		return false
+	}
+	if len(d.levels) == 0 {
+		return true
+	}
+	for _, level := range d.levels {
+		if level == sentryEvent.Level {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a comma-separated list of DSNs, skipping empty entries
+func splitDsnList(value string) []string {
+	dsns := []string{}
+	for _, dsn := range strings.Split(value, ",") {
+		dsn = strings.TrimSpace(dsn)
+		if dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
+
+// Returns the options of the agent's main client. The clients of the other
+// DSNs are created with them, not with the options of the client that a hub
+// is bound to, which may be the client of another DSN with its own overrides.
+func getMainClientOptions() sentry.ClientOptions {
+	if client := sentry.CurrentHub().Client(); client != nil {
+		return client.Options()
+	}
+	return sentry.ClientOptions{}
+}
+
+// Returns the clients of the projects that the event is routed to.
+// DSN annotations on the object take precedence over the routing rule.
+// If neither provides a DSN, the hub's own client is returned.
+func getDestinationClients(ctx context.Context, hub *sentry.Hub, route *RoutingRule, object metav1.Object) []*sentry.Client {
+	options := getMainClientOptions()
+	if object != nil {
+		if clients := dsnClientMapping.GetClientsFromObject(ctx, object, options); len(clients) > 0 {
+			return clients
+		}
+	}
+	if clients := route.getClients(ctx, options); len(clients) > 0 {
+		return clients
+	}
+	return []*sentry.Client{hub.Client()}
+}
+
+// Captures the event with each of the destination clients, and with every
+// platform destination whose filters match the event. Every destination
+// receives its own copy of the event, so that they do not affect each other.
+func captureEventToDestinations(ctx context.Context, hub *sentry.Hub, scope *sentry.Scope, sentryEvent *sentry.Event, clients []*sentry.Client, params *samplingParams) {
+	logger := zerolog.Ctx(ctx)
+
+	seenDsns := make(map[string]bool, len(clients))
+	destinations := make([]*sentry.Client, 0, len(clients))
+	addDestination := func(client *sentry.Client) {
+		dsn := client.Options().Dsn
+		if seenDsns[dsn] {
+			return
+		}
+		seenDsns[dsn] = true
+		destinations = append(destinations, client)
+	}
+
+	for _, client := range clients {
+		addDestination(client)
+	}
+	for _, destination := range agentConfig.PlatformDsns {
+		if !destination.matches(params, sentryEvent) {
+			continue
+		}
+		client, err := dsnClientMapping.GetOrCreateClient(destination.Dsn, getMainClientOptions())
+		if err != nil {
+			logger.Error().Msgf("Cannot create a client for the platform destination: %v", err)
+			continue
+		}
+		addDestination(client)
+	}
+
+	// Copies are made before anything is captured, because capturing modifies the event
+	events := make([]*sentry.Event, len(destinations))
+	for i := range destinations {
+		if i == 0 {
+			events[i] = sentryEvent
+		} else {
+			events[i] = copyEvent(sentryEvent)
+		}
+	}
+
+	for i, client := range destinations {
+		if i == 0 {
+			hub.BindClient(client)
+			countCapturedEvent(params, hub.CaptureEvent(events[i]))
+			continue
+		}
+		countCapturedEvent(params, client.CaptureEvent(events[i], nil, scope))
+	}
+}
+
+// Returns a copy of the event that can be captured independently of the original
+func copyEvent(sentryEvent *sentry.Event) *sentry.Event {
+	eventCopy := *sentryEvent
+
+	eventCopy.Breadcrumbs = append([]*sentry.Breadcrumb(nil), sentryEvent.Breadcrumbs...)
+	eventCopy.Fingerprint = append([]string(nil), sentryEvent.Fingerprint...)
+	eventCopy.Exception = append([]sentry.Exception(nil), sentryEvent.Exception...)
+	eventCopy.Threads = append([]sentry.Thread(nil), sentryEvent.Threads...)
+
+	if sentryEvent.Tags != nil {
+		eventCopy.Tags = make(map[string]string, len(sentryEvent.Tags))
+		for key, value := range sentryEvent.Tags {
+			eventCopy.Tags[key] = value
+		}
+	}
+	if sentryEvent.Extra != nil {
+		eventCopy.Extra = make(map[string]interface{}, len(sentryEvent.Extra))
+		for key, value := range sentryEvent.Extra {
+			eventCopy.Extra[key] = value
+		}
+	}
+	if sentryEvent.Contexts != nil {
+		eventCopy.Contexts = make(map[string]sentry.Context, len(sentryEvent.Contexts))
+		for key, context := range sentryEvent.Contexts {
+			contextCopy := make(sentry.Context, len(context))
+			for contextKey, value := range context {
+				contextCopy[contextKey] = value
+			}
+			eventCopy.Contexts[key] = contextCopy
+		}
+	}
+	return &eventCopy
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+func TestCaptureEventToDestinations(t *testing.T) {
+	appDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567890"
+	platformDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567891"
+
+	config, err := parseAgentConfig([]byte(`
+platformDsns:
+  - dsn: "` + platformDsn + `"
+    watchers: ["events"]
+    levels: ["error"]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse the agent config: %v", err)
+	}
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = config
+	if err := preparePlatformDestinations(); err != nil {
+		t.Fatalf("Failed to prepare platform destinations: %v", err)
+	}
+
+	// All the clients inherit the transport of the main client
+	transport := &TransportMock{}
+	mainClient, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:       "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1",
+		Transport: transport,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the main client: %v", err)
+	}
+	setMainTestClient(t, mainClient)
+	// The options of the app client must not leak into the platform client
+	appOptions := mainClient.Options()
+	appOptions.Environment = "app-environment"
+	appClient, err := dsnClientMapping.GetOrCreateClient(appDsn, appOptions)
+	if err != nil {
+		t.Fatalf("Failed to create the app client: %v", err)
+	}
+
+	ctx := context.Background()
+	hub := sentry.NewHub(mainClient, sentry.NewScope())
+	params := &samplingParams{watcher: eventsWatcherName}
+
+	// Matching event goes to both the app and the platform project
+	hub.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("scope_tag", "value")
+		sentryEvent := &sentry.Event{Message: "BackOff", Level: sentry.LevelError, Tags: map[string]string{}}
+		captureEventToDestinations(ctx, hub, scope, sentryEvent, []*sentry.Client{appClient}, params)
+	})
+	events := transport.Events()
+	if len(events) != 2 {
+		t.Fatalf("Events expected: %d, actual: %d", 2, len(events))
+	}
+	if platformClient, ok := dsnClientMapping.GetClientFromMap(platformDsn); !ok || platformClient.Options().Environment != "" {
+		t.Errorf("The platform client should be created with the options of the main client")
+	}
+	if events[0] == events[1] || events[0].EventID == events[1].EventID {
+		t.Errorf("Every destination should receive its own copy of the event")
+	}
+	for _, event := range events {
+		if event.Tags["scope_tag"] != "value" {
+			t.Errorf("The scope was not applied to the event: %v", event.Tags)
+		}
+	}
+
+	// Non-matching event only goes to the app project
+	hub.WithScope(func(scope *sentry.Scope) {
+		sentryEvent := &sentry.Event{Message: "Pulled", Level: sentry.LevelInfo}
+		captureEventToDestinations(ctx, hub, scope, sentryEvent, []*sentry.Client{appClient}, params)
+	})
+	if len(transport.Events()) != 3 {
+		t.Errorf("Events expected: %d, actual: %d", 3, len(transport.Events()))
+	}
+}
+
+func TestSplitDsnList(t *testing.T) {
+	dsns := splitDsnList(" https://a@example.com/1, ,https://b@example.com/2,")
+	if len(dsns) != 2 || dsns[0] != "https://a@example.com/1" || dsns[1] != "https://b@example.com/2" {
+		t.Errorf("Unexpected DSN list: %v", dsns)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// What to do when an owner in the middle of the owner chain cannot be fetched
+const (
+	// Use the nearest DSN annotation that can still be found (e.g. on the namespace)
+	DsnFallbackNearest = "nearest"
+	// Use the default DSN
+	DsnFallbackDefault = "default"
+)
+
+// Cached entries are also invalidated by the informers,
+// the TTL only limits the damage of a missed update
+const ownerDsnCacheTTL = 10 * time.Minute
+
+// The DSN annotations found on an owner chain. The chain starts at the
+// first owner of an object and ends at the owner with the annotations
+// (or at the root owner if there are none).
+type ownerDsnCacheEntry struct {
+	annotations map[string]string
+	owners      []types.UID
+	cachedAt    time.Time
+}
+
+// Entries are keyed by the UID of the last owner in the chain,
+// with an index from every owner in the chain to that key
+var ownerDsnCache = struct {
+	mutex   sync.Mutex
+	entries map[types.UID]*ownerDsnCacheEntry
+	owners  map[types.UID]types.UID
+}{
+	entries: make(map[types.UID]*ownerDsnCacheEntry),
+	owners:  make(map[types.UID]types.UID),
+}
+
+func getDsnFallbackPolicy() string {
+	policy := strings.ToLower(strings.TrimSpace(os.Getenv("SENTRY_K8S_DSN_FALLBACK_POLICY")))
+	if policy == DsnFallbackDefault {
+		return DsnFallbackDefault
+	}
+	return DsnFallbackNearest
+}
+
+func getDsnAnnotations(annotations map[string]string) map[string]string {
+	dsnAnnotations := map[string]string{}
+	for _, key := range []string{DSNAnnotation, DSNSecretAnnotation} {
+		if value, ok := annotations[key]; ok {
+			dsnAnnotations[key] = value
+		}
+	}
+	if len(dsnAnnotations) == 0 {
+		return nil
+	}
+	return dsnAnnotations
+}
+
+// Returns the DSN annotations of the nearest owner of the object that has them.
+// The result is false if an owner in the chain cannot be fetched.
+func findOwnerDsnAnnotations(ctx context.Context, obj metav1.Object) (map[string]string, bool) {
+	ownerRefs := obj.GetOwnerReferences()
+	if len(ownerRefs) == 0 {
+		return nil, true
+	}
+	firstOwnerUID := ownerRefs[0].UID
+
+	if entry, ok := getOwnerDsnCacheEntry(firstOwnerUID); ok {
+		return entry.annotations, true
+	}
+
+	entry := &ownerDsnCacheEntry{cachedAt: time.Now()}
+	err := walkOwnerChain(ctx, obj, func(owner metav1.Object) bool {
+		if isSameObject(owner, obj) {
+			return true
+		}
+		entry.owners = append(entry.owners, owner.GetUID())
+		entry.annotations = getDsnAnnotations(owner.GetAnnotations())
+		return entry.annotations == nil
+	})
+	if err != nil {
+		return nil, false
+	}
+	addOwnerDsnCacheEntry(entry)
+	return entry.annotations, true
+}
+
+func getOwnerDsnCacheEntry(ownerUID types.UID) (*ownerDsnCacheEntry, bool) {
+	if ownerUID == "" {
+		return nil, false
+	}
+	ownerDsnCache.mutex.Lock()
+	defer ownerDsnCache.mutex.Unlock()
+
+	key, ok := ownerDsnCache.owners[ownerUID]
+	if !ok {
+		return nil, false
+	}
+	entry, ok := ownerDsnCache.entries[key]
+	if !ok || time.Since(entry.cachedAt) > ownerDsnCacheTTL {
+		return nil, false
+	}
+	return entry, true
+}
+
+func addOwnerDsnCacheEntry(entry *ownerDsnCacheEntry) {
+	// Objects created without UIDs (e.g. in tests) cannot be cached
+	for _, uid := range entry.owners {
+		if uid == "" {
+			return
+		}
+	}
+	if len(entry.owners) == 0 {
+		return
+	}
+	key := entry.owners[len(entry.owners)-1]
+
+	ownerDsnCache.mutex.Lock()
+	defer ownerDsnCache.mutex.Unlock()
+	ownerDsnCache.entries[key] = entry
+	for _, uid := range entry.owners {
+		ownerDsnCache.owners[uid] = key
+	}
+}
+
+// Removes the cached entries that contain the owner with the given UID
+func invalidateOwnerDsnCache(ownerUID types.UID) {
+	ownerDsnCache.mutex.Lock()
+	defer ownerDsnCache.mutex.Unlock()
+
+	key, ok := ownerDsnCache.owners[ownerUID]
+	if !ok {
+		return
+	}
+	delete(ownerDsnCache.owners, ownerUID)
+	if entry, ok := ownerDsnCache.entries[key]; ok {
+		for _, uid := range entry.owners {
+			delete(ownerDsnCache.owners, uid)
+		}
+		delete(ownerDsnCache.entries, key)
+	}
+}
+
+// Informer handlers that invalidate the cached owner chains
+// when the DSN annotations of an owner change or the owner is deleted
+func ownerDsnCacheInvalidationHandler() cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldMeta, err := meta.Accessor(oldObj)
+			if err != nil {
+				return
+			}
+			newMeta, err := meta.Accessor(newObj)
+			if err != nil {
+				return
+			}
+			oldAnnotations := getDsnAnnotations(oldMeta.GetAnnotations())
+			newAnnotations := getDsnAnnotations(newMeta.GetAnnotations())
+			if len(oldAnnotations) != len(newAnnotations) ||
+				oldAnnotations[DSNAnnotation] != newAnnotations[DSNAnnotation] ||
+				oldAnnotations[DSNSecretAnnotation] != newAnnotations[DSNSecretAnnotation] {
+				invalidateOwnerDsnCache(newMeta.GetUID())
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			objMeta, err := meta.Accessor(obj)
+			if err != nil {
+				return
+			}
+			invalidateOwnerDsnCache(objMeta.GetUID())
+		},
+	}
+}
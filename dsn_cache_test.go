@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSearchDsnOwnerCache(t *testing.T) {
+	ownerDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567890"
+	namespaceDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567891"
+	namespace := "TestSearchDsnOwnerCacheNamespace"
+
+	namespaceObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Annotations: map[string]string{DSNAnnotation: namespaceDsn},
+		},
+	}
+	deploymentObj := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestSearchDsnOwnerCacheDeployment",
+			Namespace:   namespace,
+			UID:         "TestSearchDsnOwnerCacheDeploymentUID",
+			Annotations: map[string]string{DSNAnnotation: ownerDsn},
+		},
+	}
+	replicasetObj := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestSearchDsnOwnerCacheReplicaset",
+			Namespace: namespace,
+			UID:       "TestSearchDsnOwnerCacheReplicasetUID",
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "Deployment",
+				Name: deploymentObj.Name,
+				UID:  deploymentObj.UID,
+			}},
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestSearchDsnOwnerCachePod",
+			Namespace: namespace,
+			OwnerReferences: []metav1.OwnerReference{{
+				Kind: "ReplicaSet",
+				Name: replicasetObj.Name,
+				UID:  replicasetObj.UID,
+			}},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(namespaceObj, deploymentObj, replicasetObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	dsn, err := searchDsn(ctx, podObj)
+	if err != nil || dsn != ownerDsn {
+		t.Fatalf("DSN expected: %s, actual: %s (error: %v)", ownerDsn, dsn, err)
+	}
+
+	// The owner chain is cached, so it is not fetched again
+	err = fakeClientset.AppsV1().ReplicaSets(namespace).Delete(context.TODO(), replicasetObj.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("Error injecting replicaset delete: %v", err)
+	}
+	dsn, err = searchDsn(ctx, podObj)
+	if err != nil || dsn != ownerDsn {
+		t.Errorf("Cached DSN expected: %s, actual: %s (error: %v)", ownerDsn, dsn, err)
+	}
+
+	// Changing the annotation of an owner invalidates the chain
+	updatedDeploymentObj := deploymentObj.DeepCopy()
+	updatedDeploymentObj.Annotations = nil
+	ownerDsnCacheInvalidationHandler().OnUpdate(deploymentObj, updatedDeploymentObj)
+
+	// The replicaset is gone, so the nearest annotation is on the namespace
+	dsn, err = searchDsn(ctx, podObj)
+	if err != nil || dsn != namespaceDsn {
+		t.Errorf("Namespace DSN expected: %s, actual: %s (error: %v)", namespaceDsn, dsn, err)
+	}
+
+	t.Setenv("SENTRY_K8S_DSN_FALLBACK_POLICY", DsnFallbackDefault)
+	dsn, err = searchDsn(ctx, podObj)
+	if err != nil || dsn != "" {
+		t.Errorf("Default DSN expected, actual: %s (error: %v)", dsn, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/rs/zerolog"
@@ -135,9 +136,58 @@ func objectEnhancer(ctx context.Context, scope *sentry.Scope, kindObjectPair *Ki
 		}
 	}
 
+	// Link the event to the run of the monitored CronJob, after
+	// the CronJob enhancer which only knows the monitor
+	if monitorContext := getMonitorRunContext(ctx, kindObjectPair); monitorContext != nil {
+		scope.SetContext("Monitor", monitorContext)
+	}
+
 	return nil
 }
 
+// Returns the monitor slug and the check-in ID of the CronJob run
+// that the pod or job belongs to, or nil if the run is not monitored
+func getMonitorRunContext(ctx context.Context, kindObjectPair *KindObjectPair) sentry.Context {
+	if !isTruthy(os.Getenv("SENTRY_K8S_MONITOR_CRONJOBS")) {
+		return nil
+	}
+	var job *batchv1.Job
+	switch kindObjectPair.kind {
+	case KindJob:
+		job, _ = kindObjectPair.object.(*batchv1.Job)
+	case KindPod:
+		ref := metav1.GetControllerOf(kindObjectPair.object)
+		if ref == nil || ref.Kind != KindJob {
+			return nil
+		}
+		jobObj, ok := findObject(ctx, KindJob, kindObjectPair.object.GetNamespace(), ref.Name)
+		if !ok {
+			return nil
+		}
+		job, _ = jobObj.(*batchv1.Job)
+	}
+	if job == nil {
+		return nil
+	}
+
+	ref := metav1.GetControllerOf(job)
+	if ref == nil || ref.Kind != KindCronjob {
+		return nil
+	}
+	cronsMonitorData, ok := cronsMetaData.getCronsMonitorData(cronsMonitorKey(job.Namespace, ref.Name))
+	if !ok {
+		return nil
+	}
+	monitorSlug, _ := cronsMonitorData.getMonitorForJob(job)
+	monitorContext := sentry.Context{
+		"Slug": monitorSlug,
+	}
+	if checkinID, ok := getRunCheckinID(&cronsRun{object: job}, cronsMonitorData); ok {
+		monitorContext["Check-in ID"] = string(checkinID)
+	}
+	return monitorContext
+}
+
 func getKindEnhancer(kind string) func(context.Context, *sentry.Scope, metav1.Object, *sentry.Event) error {
 	switch kind {
 	case KindPod:
@@ -205,11 +255,27 @@ func jobEnhancer(ctx context.Context, scope *sentry.Scope, object metav1.Object,
 	// Add the job to the tag
 	setTagIfNotEmpty(scope, "job_name", object.GetName())
 	jobObj.ManagedFields = []metav1.ManagedFieldsEntry{}
+	jobContext := sentry.Context{}
 	metadataJSON, err := prettyJSON(jobObj.ObjectMeta)
 	if err == nil {
-		scope.SetContext(KindJob, sentry.Context{
-			"Metadata": metadataJSON,
-		})
+		jobContext["Metadata"] = metadataJSON
+	}
+	// The outcome of the job, if it already finished
+	if result := getJobRunResult(jobObj, 1); result != nil {
+		jobContext["Status"] = string(result.Status)
+		if result.Reason != "" {
+			jobContext["Reason"] = result.Reason
+		}
+		jobContext["Retries"] = result.Retries
+		if result.FailedIndexes != "" {
+			jobContext["Failed Indexes"] = result.FailedIndexes
+		}
+		if result.Duration > 0 {
+			jobContext["Duration"] = result.Duration.String()
+		}
+	}
+	if len(jobContext) > 0 {
+		scope.SetContext(KindJob, jobContext)
 	}
 
 	// Add breadcrumb with job timestamps
@@ -230,7 +296,7 @@ func cronjobEnhancer(ctx context.Context, scope *sentry.Scope, object metav1.Obj
 
 	// Set the context for corresponding slug monitor
 	scope.SetContext("Monitor", sentry.Context{
-		"Slug": object.GetName(),
+		"Slug": getMonitorSlug(cronjobObj),
 	})
 
 	// Add the cronjob to the fingerprint
@@ -93,7 +93,7 @@ func TestRunEnhancers(t *testing.T) {
 
 	// Apply the scope to the event
 	// so we can check the tags
-	scope.ApplyToEvent(event, nil)
+	scope.ApplyToEvent(event, nil, nil)
 
 	expectedTags := map[string]string{
 		"node_name": "TestRunPodEnhancerNode",
@@ -245,3 +245,30 @@ func TestOwnerRefDFS(t *testing.T) {
 		t.Errorf("The root owner's object is incorrect")
 	}
 }
+
+func TestGetMonitorRunContext(t *testing.T) {
+	t.Setenv("SENTRY_K8S_MONITOR_CRONJOBS", "1")
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-1")
+	job.Annotations = map[string]string{CheckinIDAnnotation: "080181f33ca343f89b0bf55d50abfeee"}
+	ctx, _, _ := newCronsTestContext(t, cronjob, job)
+
+	controller := true
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "backup-1-x7k2p",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: KindJob, Name: "backup-1", Controller: &controller},
+			},
+		},
+	}
+
+	expected := sentry.Context{"Slug": "backup", "Check-in ID": "080181f33ca343f89b0bf55d50abfeee"}
+	for _, pair := range []*KindObjectPair{{kind: KindJob, object: job}, {kind: KindPod, object: podObj}} {
+		monitorContext := getMonitorRunContext(ctx, pair)
+		if !reflect.DeepEqual(monitorContext, expected) {
+			t.Errorf("Monitor context of the %s expected: %v, actual: %v", pair.kind, expected, monitorContext)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	globalLogger "github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// How long a watcher can be without a working watch, or the informers can
+// be syncing, before the agent is considered broken
+const defaultHealthTimeout = 5 * time.Minute
+
+// States of a watcher
+const (
+	WatcherStateStarting   = "starting"
+	WatcherStateWatching   = "watching"
+	WatcherStateRestarting = "restarting"
+)
+
+// States of the informers of a namespace
+const (
+	InformersStateSyncing = "syncing"
+	InformersStateSynced  = "synced"
+	InformersStateFailed  = "failed"
+)
+
+type watcherStatus struct {
+	Watcher       string     `json:"watcher"`
+	Namespace     string     `json:"namespace"`
+	State         string     `json:"state"`
+	Since         time.Time  `json:"since"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastEventTime *time.Time `json:"lastEventTime,omitempty"`
+}
+
+type informersStatus struct {
+	Namespace string    `json:"namespace"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// The state of the running watchers and informers, keyed by namespace
+// (and watcher). Stopped watchers and informers are removed.
+var agentHealth = struct {
+	mutex     sync.Mutex
+	watchers  map[string]*watcherStatus
+	informers map[string]*informersStatus
+}{
+	watchers:  make(map[string]*watcherStatus),
+	informers: make(map[string]*informersStatus),
+}
+
+func getHealthTimeout() time.Duration {
+	timeoutRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_HEALTH_TIMEOUT"))
+	if timeoutRaw == "" {
+		return defaultHealthTimeout
+	}
+	timeout, err := time.ParseDuration(timeoutRaw)
+	if err != nil || timeout <= 0 {
+		globalLogger.Warn().Msgf("Invalid value of SENTRY_K8S_HEALTH_TIMEOUT: %q", timeoutRaw)
+		return defaultHealthTimeout
+	}
+	return timeout
+}
+
+func getNamespaceLabel(namespace string) string {
+	if namespace == v1.NamespaceAll {
+		return allNamespacesLabel
+	}
+	return namespace
+}
+
+// Sets the state of a watcher; the time of the state is only
+// updated when the state changes
+func setWatcherState(watcher string, namespace string, state string, err error) {
+	namespace = getNamespaceLabel(namespace)
+	key := watcher + "/" + namespace
+
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	status, ok := agentHealth.watchers[key]
+	if !ok {
+		status = &watcherStatus{Watcher: watcher, Namespace: namespace}
+		agentHealth.watchers[key] = status
+	}
+	if status.State != state {
+		status.State = state
+		status.Since = time.Now()
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	} else if state == WatcherStateWatching {
+		status.LastError = ""
+	}
+}
+
+func recordWatcherEvent(watcher string, namespace string) {
+	key := watcher + "/" + getNamespaceLabel(namespace)
+	now := time.Now()
+
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	if status, ok := agentHealth.watchers[key]; ok {
+		status.LastEventTime = &now
+	}
+}
+
+func removeWatcherStatus(watcher string, namespace string) {
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	delete(agentHealth.watchers, watcher+"/"+getNamespaceLabel(namespace))
+}
+
+func setInformersState(namespace string, state string, err error) {
+	namespace = getNamespaceLabel(namespace)
+
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	status := &informersStatus{Namespace: namespace, State: state, Since: time.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	agentHealth.informers[namespace] = status
+}
+
+func removeInformersStatus(namespace string) {
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	delete(agentHealth.informers, getNamespaceLabel(namespace))
+}
+
+// Wraps the watch function of a watcher, to record whether the
+// (re)started watches succeed. The retry watchers retry failed watches
+// on their own, so this is the only place where the failures are seen.
+func trackWatchFunc(watcher string, namespace string, watchFunc cache.WatchFunc) cache.WatchFunc {
+	return func(options metav1.ListOptions) (watch.Interface, error) {
+		watchInterface, err := watchFunc(options)
+		if err != nil {
+			setWatcherState(watcher, namespace, WatcherStateRestarting, err)
+			return nil, err
+		}
+		setWatcherState(watcher, namespace, WatcherStateWatching, nil)
+		return watchInterface, nil
+	}
+}
+
+// Records the health of the Sentry transport, from the responses
+// to the requests sent by all the clients
+type transportHealthRecorder struct {
+	base        http.RoundTripper
+	mutex       sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   string
+}
+
+type transportStatus struct {
+	Healthy     bool       `json:"healthy"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure *time.Time `json:"lastFailure,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+}
+
+var sentryTransportHealth = &transportHealthRecorder{base: http.DefaultTransport}
+
+func (t *transportHealthRecorder) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch {
+	case err != nil:
+		t.lastFailure = time.Now()
+		t.lastError = err.Error()
+	case response.StatusCode >= 500:
+		// Only the failures of Sentry count: a 4xx response (rate limiting,
+		// a rejected event or DSN) is about the request, and Sentry is reachable
+		t.lastFailure = time.Now()
+		t.lastError = fmt.Sprintf("unexpected response status: %s", response.Status)
+	default:
+		t.lastSuccess = time.Now()
+	}
+	return response, err
+}
+
+// The transport is healthy unless the latest request failed with a
+// network error or a 5xx response
+func (t *transportHealthRecorder) status() transportStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	status := transportStatus{
+		Healthy:   !t.lastFailure.After(t.lastSuccess),
+		LastError: t.lastError,
+	}
+	if !t.lastSuccess.IsZero() {
+		lastSuccess := t.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if !t.lastFailure.IsZero() {
+		lastFailure := t.lastFailure
+		status.LastFailure = &lastFailure
+	}
+	return status
+}
+
+type healthReport struct {
+	Status    string            `json:"status"`
+	Problems  []string          `json:"problems,omitempty"`
+	Watchers  []watcherStatus   `json:"watchers"`
+	Informers []informersStatus `json:"informers"`
+	Transport transportStatus   `json:"transport"`
+}
+
+// Checks the watchers and the informers. For liveness, only the states that
+// lasted longer than the timeout are problems; for readiness, all the
+// watchers must be watching, all the informers synced, and the Sentry
+// transport healthy.
+func checkHealth(now time.Time, timeout time.Duration, readiness bool) *healthReport {
+	report := &healthReport{
+		Watchers:  []watcherStatus{},
+		Informers: []informersStatus{},
+		Transport: sentryTransportHealth.status(),
+	}
+
+	agentHealth.mutex.Lock()
+	for _, status := range agentHealth.watchers {
+		report.Watchers = append(report.Watchers, *status)
+	}
+	for _, status := range agentHealth.informers {
+		report.Informers = append(report.Informers, *status)
+	}
+	agentHealth.mutex.Unlock()
+
+	sort.Slice(report.Watchers, func(i, j int) bool {
+		if report.Watchers[i].Watcher != report.Watchers[j].Watcher {
+			return report.Watchers[i].Watcher < report.Watchers[j].Watcher
+		}
+		return report.Watchers[i].Namespace < report.Watchers[j].Namespace
+	})
+	sort.Slice(report.Informers, func(i, j int) bool {
+		return report.Informers[i].Namespace < report.Informers[j].Namespace
+	})
+
+	for _, status := range report.Watchers {
+		if status.State == WatcherStateWatching {
+			continue
+		}
+		if readiness || now.Sub(status.Since) > timeout {
+			report.Problems = append(report.Problems, fmt.Sprintf("the %s watcher in namespace %q is %s since %s",
+				status.Watcher, status.Namespace, status.State, status.Since.Format(time.RFC3339)))
+		}
+	}
+	for _, status := range report.Informers {
+		switch {
+		case status.State == InformersStateFailed:
+			report.Problems = append(report.Problems, fmt.Sprintf("the informers in namespace %q failed: %s",
+				status.Namespace, status.Error))
+		case status.State == InformersStateSyncing && (readiness || now.Sub(status.Since) > timeout):
+			report.Problems = append(report.Problems, fmt.Sprintf("the informers in namespace %q are syncing since %s",
+				status.Namespace, status.Since.Format(time.RFC3339)))
+		}
+	}
+	if readiness && !report.Transport.Healthy {
+		report.Problems = append(report.Problems, fmt.Sprintf("the Sentry transport is failing: %s", report.Transport.LastError))
+	}
+
+	report.Status = "ok"
+	if len(report.Problems) > 0 {
+		report.Status = "unhealthy"
+	}
+	return report
+}
+
+func healthHandler(readiness bool) http.Handler {
+	timeout := getHealthTimeout()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := checkHealth(time.Now(), timeout, readiness)
+		w.Header().Set("Content-Type", "application/json")
+		if len(report.Problems) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			globalLogger.Warn().Msgf("Cannot write the health report: %v", err)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func resetAgentHealth() {
+	agentHealth.mutex.Lock()
+	defer agentHealth.mutex.Unlock()
+	agentHealth.watchers = make(map[string]*watcherStatus)
+	agentHealth.informers = make(map[string]*informersStatus)
+}
+
+func TestCheckHealth(t *testing.T) {
+	resetAgentHealth()
+	defer resetAgentHealth()
+
+	timeout := time.Minute
+	now := time.Now()
+
+	setWatcherState(eventsWatcherName, "", WatcherStateWatching, nil)
+	setWatcherState(podsWatcherName, "", WatcherStateStarting, nil)
+	setInformersState("", InformersStateSyncing, nil)
+
+	// Starting up: alive, but not ready yet
+	if report := checkHealth(now, timeout, false); len(report.Problems) != 0 {
+		t.Errorf("Liveness problems expected: none, actual: %v", report.Problems)
+	}
+	if report := checkHealth(now, timeout, true); len(report.Problems) != 2 {
+		t.Errorf("Readiness problems expected: 2, actual: %v", report.Problems)
+	}
+
+	setWatcherState(podsWatcherName, "", WatcherStateWatching, nil)
+	setInformersState("", InformersStateSynced, nil)
+	recordWatcherEvent(eventsWatcherName, "")
+	report := checkHealth(now, timeout, true)
+	if report.Status != "ok" {
+		t.Errorf("Readiness expected: ok, actual: %v", report.Problems)
+	}
+	if len(report.Watchers) != 2 || report.Watchers[0].Namespace != allNamespacesLabel || report.Watchers[0].LastEventTime == nil {
+		t.Errorf("Unexpected watchers in the report: %#v", report.Watchers)
+	}
+
+	// A watcher that cannot watch for longer than the timeout is not alive
+	trackedWatchFunc := trackWatchFunc(eventsWatcherName, "", func(options metav1.ListOptions) (watch.Interface, error) {
+		return nil, errors.New("forbidden")
+	})
+	if _, err := trackedWatchFunc(metav1.ListOptions{}); err == nil {
+		t.Fatalf("Expected the watch error to be returned")
+	}
+	if report := checkHealth(now, timeout, false); len(report.Problems) != 0 {
+		t.Errorf("Liveness problems expected: none, actual: %v", report.Problems)
+	}
+	report = checkHealth(now.Add(2*timeout), timeout, false)
+	if len(report.Problems) != 1 || report.Watchers[0].LastError != "forbidden" {
+		t.Errorf("Liveness problems expected: 1, actual: %v (%#v)", report.Problems, report.Watchers[0])
+	}
+
+	// Informers that failed are not alive
+	removeWatcherStatus(eventsWatcherName, "")
+	setInformersState("", InformersStateFailed, errors.New("failed to get clientset"))
+	if report := checkHealth(now, timeout, false); report.Status == "ok" || len(report.Watchers) != 1 {
+		t.Errorf("Liveness expected: unhealthy, actual: %v (watchers: %#v)", report.Status, report.Watchers)
+	}
+}
+
+func TestTransportHealthRecorder(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	recorder := &transportHealthRecorder{base: http.DefaultTransport}
+	client := &http.Client{Transport: recorder}
+	send := func() {
+		response, err := client.Get(server.URL)
+		if err == nil {
+			response.Body.Close()
+		}
+	}
+
+	if !recorder.status().Healthy {
+		t.Errorf("A transport without requests should be healthy")
+	}
+	send()
+	if !recorder.status().Healthy {
+		t.Errorf("The transport should be healthy after a successful request")
+	}
+	status = http.StatusTooManyRequests
+	send()
+	if !recorder.status().Healthy {
+		t.Errorf("Rate limiting should not make the transport unhealthy")
+	}
+	status = http.StatusBadRequest
+	send()
+	if !recorder.status().Healthy {
+		t.Errorf("A rejected request should not make the transport unhealthy")
+	}
+	status = http.StatusServiceUnavailable
+	send()
+	if transportStatus := recorder.status(); transportStatus.Healthy || transportStatus.LastError == "" {
+		t.Errorf("The transport should be unhealthy after a failed request: %#v", transportStatus)
+	}
+	status = http.StatusOK
+	send()
+	if !recorder.status().Healthy {
+		t.Errorf("The transport should be healthy again after a successful request")
+	}
+
+	// Network errors make the transport unhealthy
+	server.Close()
+	send()
+	if recorder.status().Healthy {
+		t.Errorf("The transport should be unhealthy after a network error")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	globalLogger "github.com/rs/zerolog/log"
+)
+
+const defaultHTTPAddress = ":8080"
+
+// The address of the agent's HTTP server (metrics and health checks),
+// "off" disables the server
+func getHTTPAddress() string {
+	address := strings.TrimSpace(os.Getenv("SENTRY_K8S_HTTP_ADDRESS"))
+	if address == "" {
+		return defaultHTTPAddress
+	}
+	if strings.ToLower(address) == "off" {
+		return ""
+	}
+	return address
+}
+
+func newHTTPServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	mux.Handle("/healthz", healthHandler(false))
+	mux.Handle("/readyz", healthHandler(true))
+	return mux
+}
+
+// Serves the agent's HTTP endpoints until the context is cancelled
+func runHTTPServer(ctx context.Context, address string) {
+	defer reportPanicAndCrash()
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           newHTTPServeMux(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			globalLogger.Warn().Msgf("Cannot shut down the HTTP server: %v", err)
+		}
+	}()
+
+	globalLogger.Info().Msgf("Serving the HTTP endpoints on %s", address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		globalLogger.Error().Msgf("HTTP server error: %v", err)
+	}
+}
@@ -11,45 +11,44 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
-func createCronjobInformer(ctx context.Context, factory informers.SharedInformerFactory) (cache.SharedIndexInformer, error) {
+// Creates the cronjob informer. If cronjob monitoring is enabled, the
+// CronJobs and the Jobs of the job informer are checked in like the
+// schedules and the runs of the other schedulers.
+func createCronjobInformer(ctx context.Context, factory informers.SharedInformerFactory, jobInformer cache.SharedIndexInformer) (cache.SharedIndexInformer, error) {
 	logger := zerolog.Ctx(ctx)
 
 	logger.Debug().Msgf("Starting cronjob informer\n")
 
 	cronjobInformer := factory.Batch().V1().CronJobs().Informer()
 
-	var handler cache.ResourceEventHandlerFuncs
-
-	handler.AddFunc = func(obj interface{}) {
-		cronjob := obj.(*batchv1.CronJob)
-		logger.Debug().Msgf("ADD: CronJob Added to Store: %s\n", cronjob.GetName())
-		_, ok := cronsMetaData.getCronsMonitorData(cronjob.Name)
-		if ok {
-			logger.Debug().Msgf("cronJob %s already exists in the crons informer data struct...\n", cronjob.Name)
-		} else {
-			cronsMetaData.addCronsMonitorData(cronjob.Name, NewCronsMonitorData(cronjob.Name, cronjob.Spec.Schedule, cronjob.Spec.JobTemplate.Spec.Completions))
-		}
-	}
-
-	handler.DeleteFunc = func(obj interface{}) {
-		cronjob := obj.(*batchv1.CronJob)
-		logger.Debug().Msgf("DELETE: CronJob deleted from Store: %s\n", cronjob.GetName())
-		_, ok := cronsMetaData.getCronsMonitorData(cronjob.Name)
-		if ok {
-			cronsMetaData.deleteCronsMonitorData(cronjob.Name)
-			logger.Debug().Msgf("cronJob %s deleted from the crons informer data struct...\n", cronjob.Name)
-		} else {
-			logger.Debug().Msgf("cronJob %s not in the crons informer data struct...\n", cronjob.Name)
-		}
-	}
-
 	// Check if cronjob monitoring is enabled
 	if isTruthy(os.Getenv("SENTRY_K8S_MONITOR_CRONJOBS")) {
-		logger.Info().Msgf("Add cronjob informer handlers for cronjob monitoring")
-		cronjobInformer.AddEventHandler(handler)
+		logger.Info().Msgf("Add cronjob and job informer handlers for cronjob monitoring")
+		addCronsSchedulerHandlers(ctx, cronJobScheduler{}, cronjobInformer, jobInformer)
 	} else {
 		logger.Info().Msgf("Cronjob monitoring is disabled")
 	}
 
 	return cronjobInformer, nil
 }
+
+func isCronJobSuspended(cronjob *batchv1.CronJob) bool {
+	return cronjob.Spec.Suspend != nil && *cronjob.Spec.Suspend
+}
+
+// Mutes the monitor of a suspended schedule, so that the runs it does not
+// start are not reported as missed, and unmutes it when it is resumed
+func setCronsMonitorMuted(ctx context.Context, cronsMonitorData *CronsMonitorData, muted bool) {
+	logger := zerolog.Ctx(ctx)
+	monitorSlug, _ := cronsMonitorData.getMonitor()
+	api := getSentryMonitorAPI()
+	if api == nil {
+		logger.Info().Msgf("Monitor %s cannot be muted or unmuted without SENTRY_K8S_SENTRY_AUTH_TOKEN and SENTRY_K8S_SENTRY_ORG", monitorSlug)
+		return
+	}
+	if err := api.setMonitorMuted(ctx, monitorSlug, muted); err != nil {
+		logger.Warn().Msgf("Cannot update monitor %s (muted: %v): %v", monitorSlug, muted, err)
+		return
+	}
+	logger.Info().Msgf("Monitor %s muted: %v", monitorSlug, muted)
+}
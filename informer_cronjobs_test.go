@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+)
+
+type monitorAPIRequest struct {
+	path string
+	body map[string]interface{}
+}
+
+// Starts a fake Sentry API and configures the agent to use it
+func newMonitorAPIServer(t *testing.T) func() []monitorAPIRequest {
+	var mu sync.Mutex
+	var requests []monitorAPIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests = append(requests, monitorAPIRequest{path: r.URL.Path, body: body})
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("SENTRY_K8S_SENTRY_AUTH_TOKEN", "test-token")
+	t.Setenv("SENTRY_K8S_SENTRY_ORG", "acme")
+	t.Setenv("SENTRY_K8S_SENTRY_API_URL", server.URL+"/api/0")
+	return func() []monitorAPIRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]monitorAPIRequest{}, requests...)
+	}
+}
+
+func TestSyncCronJobSchedule(t *testing.T) {
+	getRequests := newMonitorAPIServer(t)
+	cronjob := newCronsTestCronJob()
+	ctx, _, _ := newCronsTestContext(t, cronjob)
+
+	// A new schedule is sent to Sentry
+	updated := cronjob.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Spec.Schedule = "0 * * * *"
+	syncCronsSchedule(ctx, cronJobScheduler{}, cronjob, updated)
+
+	cronsMonitorData, _ := cronsMetaData.getCronsMonitorData(cronsMonitorKey("default", "backup"))
+	if _, monitorConfig := cronsMonitorData.getMonitor(); monitorConfig.Schedule != sentry.CrontabSchedule("0 * * * *") {
+		t.Errorf("The schedule of the monitor should be updated: %#v", monitorConfig.Schedule)
+	}
+
+	// The monitor is muted while the CronJob is suspended
+	suspended := updated.DeepCopy()
+	suspend := true
+	suspended.Spec.Suspend = &suspend
+	syncCronsSchedule(ctx, cronJobScheduler{}, updated, suspended)
+	syncCronsSchedule(ctx, cronJobScheduler{}, suspended, updated)
+
+	requests := getRequests()
+	if len(requests) != 3 {
+		t.Fatalf("API requests expected: 3, actual: %#v", requests)
+	}
+	for _, request := range requests {
+		if request.path != "/api/0/organizations/acme/monitors/backup/" {
+			t.Errorf("Unexpected path: %s", request.path)
+		}
+	}
+	config, _ := requests[0].body["config"].(map[string]interface{})
+	if config["schedule"] != "0 * * * *" || config["schedule_type"] != "crontab" {
+		t.Errorf("Unexpected monitor config: %#v", requests[0].body)
+	}
+	if requests[1].body["is_muted"] != true || requests[2].body["is_muted"] != false {
+		t.Errorf("The monitor should be muted, then unmuted: %#v, %#v", requests[1].body, requests[2].body)
+	}
+}
+
+func TestRemoveCronJobMonitor(t *testing.T) {
+	getRequests := newMonitorAPIServer(t)
+	cronjob := newCronsTestCronJob()
+	job := newCronsTestJob(cronjob, "backup-1")
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+
+	removeCronsMonitor(ctx, cronsMonitorKey("default", "backup"), cronjob)
+
+	if _, ok := cronsMetaData.getCronsMonitorData(cronsMonitorKey("default", "backup")); ok {
+		t.Errorf("The CronJob should be removed from the crons registry")
+	}
+	events := transport.Events()
+	if len(events) != 2 || events[1].CheckIn.ID != events[0].CheckIn.ID || events[1].CheckIn.Status != sentry.CheckInStatusError {
+		t.Errorf("The running job should be closed with an error check-in: %#v", events)
+	}
+	requests := getRequests()
+	if len(requests) != 1 || requests[0].body["status"] != "disabled" {
+		t.Errorf("The monitor should be disabled: %#v", requests)
+	}
+}
+
+func TestSyncCronJobScheduleStopsMonitoring(t *testing.T) {
+	getRequests := newMonitorAPIServer(t)
+	cronjob := newCronsTestCronJob()
+	cronjob.ResourceVersion = "1"
+	job := newCronsTestJob(cronjob, "backup-1")
+	ctx, transport, _ := newCronsTestContext(t, cronjob, job)
+	if err := checkinCronsRun(ctx, cronJobScheduler{}, job, EventHandlerAdd); err != nil {
+		t.Fatal(err)
+	}
+
+	// The monitor of the old slug is disabled when the slug changes
+	renamed := cronjob.DeepCopy()
+	renamed.ResourceVersion = "2"
+	renamed.Annotations = map[string]string{MonitorSlugAnnotation: "nightly-backup"}
+	syncCronsSchedule(ctx, cronJobScheduler{}, cronjob, renamed)
+
+	requests := getRequests()
+	if len(requests) != 2 || requests[0].path != "/api/0/organizations/acme/monitors/nightly-backup/" {
+		t.Fatalf("The config of the new monitor should be sent: %#v", requests)
+	}
+	if requests[1].path != "/api/0/organizations/acme/monitors/backup/" || requests[1].body["status"] != "disabled" {
+		t.Errorf("The monitor of the old slug should be disabled: %#v", requests[1])
+	}
+
+	// The runs are closed and the monitor is disabled once the CronJob is opted out
+	optedOut := renamed.DeepCopy()
+	optedOut.ResourceVersion = "3"
+	optedOut.Annotations[MonitorAnnotation] = "false"
+	syncCronsSchedule(ctx, cronJobScheduler{}, renamed, optedOut)
+
+	if _, ok := cronsMetaData.getCronsMonitorData(cronsMonitorKey("default", "backup")); ok {
+		t.Errorf("The CronJob should be removed from the crons registry")
+	}
+	events := transport.Events()
+	if len(events) != 2 || events[1].CheckIn.ID != events[0].CheckIn.ID || events[1].CheckIn.Status != sentry.CheckInStatusError {
+		t.Errorf("The running job should be closed with an error check-in: %#v", events)
+	}
+	requests = getRequests()
+	if len(requests) != 3 || requests[2].path != "/api/0/organizations/acme/monitors/nightly-backup/" || requests[2].body["status"] != "disabled" {
+		t.Errorf("The monitor should be disabled: %#v", requests)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Only secrets with this label are watched, to keep
+// the agent away from unrelated secrets
+const defaultDsnSecretLabelSelector = "k8s.sentry.io/dsn-secret=true"
+
+// One secret informer per watched namespace
+var secretInformers = struct {
+	mutex     sync.RWMutex
+	informers []cache.SharedIndexInformer
+}{}
+
+func getDsnSecretLabelSelector() string {
+	selector := strings.TrimSpace(os.Getenv("SENTRY_K8S_DSN_SECRET_LABEL_SELECTOR"))
+	if selector == "" {
+		return defaultDsnSecretLabelSelector
+	}
+	return selector
+}
+
+// Creates a factory for secret informers, limited by the DSN secret label selector
+func createSecretInformerFactory(clientset ClientsetInterface, namespace string) informers.SharedInformerFactory {
+	labelSelector := getDsnSecretLabelSelector()
+	return informers.NewSharedInformerFactoryWithOptions(
+		clientset,
+		5*time.Second,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+		}),
+	)
+}
+
+func createSecretInformer(ctx context.Context, factory informers.SharedInformerFactory) (cache.SharedIndexInformer, error) {
+	logger := zerolog.Ctx(ctx)
+
+	logger.Debug().Msgf("starting secret informer\n")
+
+	secretInformer := factory.Core().V1().Secrets().Informer()
+
+	var handler cache.ResourceEventHandlerFuncs
+
+	handler.UpdateFunc = func(oldObj, newObj interface{}) {
+		oldSecret := oldObj.(*v1.Secret)
+		newSecret := newObj.(*v1.Secret)
+		if oldSecret.ResourceVersion == newSecret.ResourceVersion {
+			return
+		}
+		// Rotate the clients of the DSNs that were changed or removed
+		for key, oldValue := range oldSecret.Data {
+			newValue, ok := newSecret.Data[key]
+			if ok && string(newValue) == string(oldValue) {
+				continue
+			}
+			logger.Info().Msgf("DSN secret %s/%s was updated, rotating client", newSecret.Namespace, newSecret.Name)
+			dsnClientMapping.RemoveClient(strings.TrimSpace(string(oldValue)))
+		}
+	}
+
+	handler.DeleteFunc = func(obj interface{}) {
+		removeDsnSecretClients(ctx, obj)
+	}
+
+	secretInformer.AddEventHandler(trackEventHandler(handler))
+
+	secretInformers.mutex.Lock()
+	secretInformers.informers = append(secretInformers.informers, secretInformer)
+	secretInformers.mutex.Unlock()
+
+	return secretInformer, nil
+}
+
+// Removes the clients of the DSNs of a deleted secret. The deletion may have
+// been missed while the watch was disconnected, the last known state is used then.
+func removeDsnSecretClients(ctx context.Context, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(*v1.Secret)
+	if !ok {
+		return
+	}
+	zerolog.Ctx(ctx).Info().Msgf("DSN secret %s/%s was deleted, removing clients", secret.Namespace, secret.Name)
+	for _, value := range secret.Data {
+		dsnClientMapping.RemoveClient(strings.TrimSpace(string(value)))
+	}
+}
+
+// Removes a stopped informer, so that its stale cache is not used
+func removeSecretInformer(informer cache.SharedIndexInformer) {
+	secretInformers.mutex.Lock()
+	defer secretInformers.mutex.Unlock()
+
+	for i, existing := range secretInformers.informers {
+		if existing == informer {
+			secretInformers.informers = append(secretInformers.informers[:i], secretInformers.informers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Looks up a DSN secret in the informer caches
+func getDsnSecret(namespace string, name string) (*v1.Secret, bool) {
+	secretInformers.mutex.RLock()
+	defer secretInformers.mutex.RUnlock()
+
+	for _, informer := range secretInformers.informers {
+		obj, ok, err := informer.GetIndexer().GetByKey(namespace + "/" + name)
+		if ok && err == nil {
+			return obj.(*v1.Secret), true
+		}
+	}
+	return nil, false
+}
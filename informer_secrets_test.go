@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDsnSecretResolutionAndRotation(t *testing.T) {
+	oldDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567890"
+	newDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567891"
+
+	secretObj := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestDsnSecret",
+			Namespace: "TestDsnSecretNamespace",
+			Labels:    map[string]string{"k8s.sentry.io/dsn-secret": "true"},
+		},
+		Data: map[string][]byte{"sentry-dsn": []byte(oldDsn + "\n")},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestDsnSecretPod",
+			Namespace:   "TestDsnSecretNamespace",
+			Annotations: map[string]string{DSNSecretAnnotation: "TestDsnSecret/sentry-dsn"},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(secretObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	defer func() { secretInformers.informers = nil }()
+	factory := createSecretInformerFactory(fakeClientset, "TestDsnSecretNamespace")
+	secretInformer, err := createSecretInformer(ctx, factory)
+	if err != nil {
+		t.Fatalf("Failed to create the secret informer: %v", err)
+	}
+	doneChan := make(chan struct{})
+	defer close(doneChan)
+	factory.Start(doneChan)
+	if ok := cache.WaitForCacheSync(doneChan, secretInformer.HasSynced); !ok {
+		t.Fatalf("The secret informer failed to sync")
+	}
+
+	dsn, err := searchDsn(ctx, podObj)
+	if err != nil {
+		t.Fatalf("Failed to resolve the DSN secret: %v", err)
+	}
+	if dsn != oldDsn {
+		t.Errorf("DSN expected: %s, actual: %s", oldDsn, dsn)
+	}
+
+	_, err = dsnClientMapping.AddClientToMap(sentry.ClientOptions{Dsn: oldDsn})
+	if err != nil {
+		t.Fatalf("Failed to add client to map")
+	}
+
+	// Rotate the DSN stored in the secret
+	secretObj = secretObj.DeepCopy()
+	secretObj.ResourceVersion = "2"
+	secretObj.Data["sentry-dsn"] = []byte(newDsn)
+	_, err = fakeClientset.CoreV1().Secrets("TestDsnSecretNamespace").Update(context.TODO(), secretObj, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error injecting secret update: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dsn, err = searchDsn(ctx, podObj)
+		_, oldClientFound := dsnClientMapping.GetClientFromMap(oldDsn)
+		if err == nil && dsn == newDsn && !oldClientFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The DSN was not rotated: DSN %q, old client still present: %v", dsn, oldClientFound)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A deletion missed by the watch removes the clients too
+	_, err = dsnClientMapping.AddClientToMap(sentry.ClientOptions{Dsn: newDsn})
+	if err != nil {
+		t.Fatalf("Failed to add client to map")
+	}
+	removeDsnSecretClients(ctx, cache.DeletedFinalStateUnknown{Key: "TestDsnSecretNamespace/TestDsnSecret", Obj: secretObj})
+	if _, ok := dsnClientMapping.GetClientFromMap(newDsn); ok {
+		t.Errorf("The client of the deleted secret should be removed")
+	}
+
+	// Unknown secrets cannot be resolved
+	podObj.Annotations[DSNSecretAnnotation] = "TestDsnSecretUnknown"
+	if _, err := searchDsn(ctx, podObj); err == nil {
+		t.Errorf("Expected an error for an unknown DSN secret")
+	}
+}
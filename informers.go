@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"os"
 	"time"
 
 	"k8s.io/client-go/informers"
@@ -18,10 +19,17 @@ var deploymentInformer cache.SharedIndexInformer
 // if we opt into cronjob, attach the job/cronjob event handlers
 // and add to the crons monitor data struct for Sentry Crons
 func startInformers(ctx context.Context, namespace string) error {
+	setInformersState(namespace, InformersStateSyncing, nil)
+	informerHandlers.start()
+
 	clientset, err := getClientsetFromContext(ctx)
 	if err != nil {
 		return errors.New("failed to get clientset")
 	}
+	// The informers are stopped when the context is cancelled,
+	// but the handlers complete the events they are processing
+	stopChan := ctx.Done()
+	ctx = detachContext(ctx)
 
 	// Create factory that will produce both the cronjob informer and job informer
 	factory := informers.NewSharedInformerFactoryWithOptions(
@@ -36,7 +44,7 @@ func startInformers(ctx context.Context, namespace string) error {
 		return err
 	}
 	// Create the cronjob informer
-	cronjobInformer, err = createCronjobInformer(ctx, factory)
+	cronjobInformer, err = createCronjobInformer(ctx, factory, jobInformer)
 	if err != nil {
 		return err
 	}
@@ -51,29 +59,73 @@ func startInformers(ctx context.Context, namespace string) error {
 		return err
 	}
 
-	// Channel to tell the factory to stop the informers
-	doneChan := make(chan struct{})
-	factory.Start(doneChan)
+	// Cached DSN lookups are invalidated when the annotations of the owners change
+	if dsnClientMapping.customDsnFlag {
+		for _, informer := range []cache.SharedIndexInformer{jobInformer, cronjobInformer, replicasetInformer, deploymentInformer} {
+			informer.AddEventHandler(trackEventHandler(ownerDsnCacheInvalidationHandler()))
+		}
+	}
+
+	// DSN secrets are watched with a separate factory, limited by label
+	var secretFactory informers.SharedInformerFactory
+	var secretInformer cache.SharedIndexInformer
+	if dsnClientMapping.customDsnFlag {
+		secretFactory = createSecretInformerFactory(clientset, namespace)
+		secretInformer, err = createSecretInformer(ctx, secretFactory)
+		if err != nil {
+			return err
+		}
+	}
+
+	factory.Start(stopChan)
+	if secretFactory != nil {
+		secretFactory.Start(stopChan)
+	}
 
 	// Sync the cronjob informer cache
-	if ok := cache.WaitForCacheSync(doneChan, cronjobInformer.HasSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopChan, cronjobInformer.HasSynced); !ok {
 		return errors.New("cronjob informer failed to sync")
 	}
 	// Sync the job informer cache
-	if ok := cache.WaitForCacheSync(doneChan, jobInformer.HasSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopChan, jobInformer.HasSynced); !ok {
 		return errors.New("job informer failed to sync")
 	}
 	// Sync the replicaset informer cache
-	if ok := cache.WaitForCacheSync(doneChan, replicasetInformer.HasSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopChan, replicasetInformer.HasSynced); !ok {
 		return errors.New("replicaset informer failed to sync")
 	}
 	// Sync the deployment informer cache
-	if ok := cache.WaitForCacheSync(doneChan, deploymentInformer.HasSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopChan, deploymentInformer.HasSynced); !ok {
 		return errors.New("deployment informer failed to sync")
 	}
 
-	// Wait for the channel to be closed
-	<-doneChan
+	// Sync the secret informer cache
+	if secretInformer != nil {
+		if ok := cache.WaitForCacheSync(stopChan, secretInformer.HasSynced); !ok {
+			return errors.New("secret informer failed to sync")
+		}
+	}
+
+	// Argo CronWorkflows are monitored with the dynamic client
+	if isArgoCronWorkflowsMonitoringEnabled() {
+		if err := startArgoCronWorkflowInformers(ctx, namespace, stopChan); err != nil {
+			return err
+		}
+	}
+	setInformersState(namespace, InformersStateSynced, nil)
+
+	// The runs that started or ended while the agent was not running are
+	// reconciled once both the cronjobs and the jobs are known
+	if isTruthy(os.Getenv("SENTRY_K8S_MONITOR_CRONJOBS")) {
+		reconcileCronsRuns(ctx, cronJobScheduler{}, jobInformer.GetStore().List())
+		go watchMissedCronJobRuns(ctx, stopChan, cronjobInformer)
+	}
+
+	// Wait for the agent to shut down (or to lose the leadership)
+	<-stopChan
+	if secretInformer != nil {
+		removeSecretInformer(secretInformer)
+	}
 
 	return nil
 }
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	defaultLeaseName          = "sentry-kubernetes"
+	defaultLeaseDuration      = 15 * time.Second
+	leaderCheckpointInterval  = 10 * time.Second
+	serviceAccountNamespaceFn = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// The time of the last event processed by the leader is stored in a ConfigMap
+// next to the Lease, so that a new leader resumes watching events from there.
+// (Storing it on the Lease itself would race with the lease renewals.)
+const (
+	leaderCheckpointConfigMapSuffix = "-checkpoint"
+	leaderCheckpointKey             = "lastEventTime"
+	// A new leader resumes a bit before the checkpoint, because the
+	// LastTimestamp of events only has a precision of one second
+	leaderCheckpointOverlap = time.Second
+)
+
+var eventsCheckpoint = struct {
+	mutex sync.Mutex
+	// The time of the latest event that was processed
+	lastEventTime time.Time
+	// The time to resume watching events from, set when leadership is acquired
+	resumeFrom time.Time
+}{}
+
+func isLeaderElectionEnabled() bool {
+	return isTruthy(os.Getenv("SENTRY_K8S_LEADER_ELECTION"))
+}
+
+func recordProcessedEventTime(eventTime time.Time) {
+	eventsCheckpoint.mutex.Lock()
+	defer eventsCheckpoint.mutex.Unlock()
+	if eventTime.After(eventsCheckpoint.lastEventTime) {
+		eventsCheckpoint.lastEventTime = eventTime
+	}
+}
+
+func getLastProcessedEventTime() time.Time {
+	eventsCheckpoint.mutex.Lock()
+	defer eventsCheckpoint.mutex.Unlock()
+	return eventsCheckpoint.lastEventTime
+}
+
+// Returns the time to resume watching events from, or zero time if unknown
+func getEventsResumeTime() time.Time {
+	eventsCheckpoint.mutex.Lock()
+	defer eventsCheckpoint.mutex.Unlock()
+	return eventsCheckpoint.resumeFrom
+}
+
+func setEventsResumeTime(resumeFrom time.Time) {
+	eventsCheckpoint.mutex.Lock()
+	defer eventsCheckpoint.mutex.Unlock()
+	eventsCheckpoint.resumeFrom = resumeFrom
+	if resumeFrom.After(eventsCheckpoint.lastEventTime) {
+		eventsCheckpoint.lastEventTime = resumeFrom
+	}
+}
+
+type leaderElectionConfig struct {
+	namespace     string
+	leaseName     string
+	identity      string
+	leaseDuration time.Duration
+}
+
+// Returns the namespace for the agent's leases: the value of the given
+// environment variable, or the namespace of the agent's pod
+func getLeaseNamespace(envName string) string {
+	namespace := strings.TrimSpace(os.Getenv(envName))
+	if namespace == "" {
+		namespaceRaw, err := os.ReadFile(serviceAccountNamespaceFn)
+		if err == nil {
+			namespace = strings.TrimSpace(string(namespaceRaw))
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace
+}
+
+// Returns the identity of this replica: the pod name, or the hostname
+func getReplicaIdentity() (string, error) {
+	identity := strings.TrimSpace(os.Getenv("POD_NAME"))
+	if identity != "" {
+		return identity, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine the replica identity: %v", err)
+	}
+	return hostname, nil
+}
+
+func getLeaseDuration(envName string) (time.Duration, error) {
+	leaseDurationRaw := strings.TrimSpace(os.Getenv(envName))
+	if leaseDurationRaw == "" {
+		return defaultLeaseDuration, nil
+	}
+	leaseDuration, err := time.ParseDuration(leaseDurationRaw)
+	if err != nil || leaseDuration < 3*time.Second {
+		return 0, fmt.Errorf("invalid lease duration in %s: %q", envName, leaseDurationRaw)
+	}
+	return leaseDuration, nil
+}
+
+func getLeaderElectionConfig() (*leaderElectionConfig, error) {
+	config := &leaderElectionConfig{
+		namespace: getLeaseNamespace("SENTRY_K8S_LEADER_ELECTION_NAMESPACE"),
+		leaseName: strings.TrimSpace(os.Getenv("SENTRY_K8S_LEADER_ELECTION_LEASE_NAME")),
+	}
+	if config.leaseName == "" {
+		config.leaseName = defaultLeaseName
+	}
+
+	var err error
+	if config.identity, err = getReplicaIdentity(); err != nil {
+		return nil, err
+	}
+	if config.leaseDuration, err = getLeaseDuration("SENTRY_K8S_LEADER_ELECTION_LEASE_DURATION"); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Runs the given function only while this replica is the leader. When the
+// leadership is lost the function's context is cancelled, and the replica
+// takes part in the election again. Returns when the context is cancelled.
+func runWithLeaderElection(ctx context.Context, clientset ClientsetInterface, config *leaderElectionConfig, run func(ctx context.Context)) error {
+	logger := zerolog.Ctx(ctx)
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.leaseName,
+			Namespace: config.namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.identity,
+		},
+	}
+
+	for ctx.Err() == nil {
+		// The elector starts the callback in a goroutine, so it is tracked
+		// to wait for the processing to stop before the next election
+		var mutex sync.Mutex
+		started, electionEnded := false, false
+		runDone := make(chan struct{})
+
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            config.leaseName,
+			LeaseDuration:   config.leaseDuration,
+			RenewDeadline:   config.leaseDuration * 2 / 3,
+			RetryPeriod:     config.leaseDuration / 7,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(leaderCtx context.Context) {
+					defer reportPanicAndCrash()
+					mutex.Lock()
+					if electionEnded {
+						mutex.Unlock()
+						return
+					}
+					started = true
+					mutex.Unlock()
+					defer close(runDone)
+
+					logger.Info().Msgf("Acquired the leadership (lease %s/%s)", config.namespace, config.leaseName)
+					checkpointer := &leaderCheckpointer{clientset: clientset, config: config}
+					checkpointer.load(leaderCtx)
+
+					checkpointDone := make(chan struct{})
+					go func() {
+						defer reportPanicAndCrash()
+						defer close(checkpointDone)
+						checkpointer.run(leaderCtx)
+					}()
+					run(leaderCtx)
+					<-checkpointDone
+
+					// The final checkpoint is stored after all the events were processed
+					finalCtx, cancel := context.WithTimeout(detachContext(leaderCtx), 2*time.Second)
+					checkpointer.save(finalCtx)
+					cancel()
+				},
+				OnStoppedLeading: func() {
+					logger.Info().Msgf("Not leading (lease %s/%s)", config.namespace, config.leaseName)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != config.identity {
+						logger.Info().Msgf("The current leader is %s", identity)
+					}
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		elector.Run(ctx)
+
+		// Wait until the leader stops processing events
+		mutex.Lock()
+		electionEnded = true
+		wasStarted := started
+		mutex.Unlock()
+		if wasStarted {
+			<-runDone
+		}
+	}
+	return nil
+}
+
+// Stores the time of the last processed event in the checkpoint ConfigMap
+type leaderCheckpointer struct {
+	clientset ClientsetInterface
+	config    *leaderElectionConfig
+	lastSaved time.Time
+}
+
+func (c *leaderCheckpointer) configMapName() string {
+	return c.config.leaseName + leaderCheckpointConfigMapSuffix
+}
+
+// Reads the checkpoint of the previous leader
+func (c *leaderCheckpointer) load(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	configMap, err := c.clientset.CoreV1().ConfigMaps(c.config.namespace).Get(ctx, c.configMapName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		logger.Warn().Msgf("Cannot read the leader checkpoint: %v", err)
+		return
+	}
+	checkpointRaw, ok := configMap.Data[leaderCheckpointKey]
+	if !ok {
+		return
+	}
+	checkpoint, err := time.Parse(time.RFC3339Nano, checkpointRaw)
+	if err != nil {
+		logger.Warn().Msgf("Invalid leader checkpoint %q: %v", checkpointRaw, err)
+		return
+	}
+	resumeFrom := checkpoint.Add(-leaderCheckpointOverlap)
+	logger.Info().Msgf("Resuming from the leader checkpoint: %s (from %s)",
+		checkpoint.Format(time.RFC3339Nano), resumeFrom.Format(time.RFC3339Nano))
+	setEventsResumeTime(resumeFrom)
+	c.lastSaved = checkpoint
+}
+
+// Periodically stores the checkpoint, until the context is cancelled
+func (c *leaderCheckpointer) run(ctx context.Context) {
+	ticker := time.NewTicker(leaderCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.save(ctx)
+		}
+	}
+}
+
+// Stores the checkpoint if there were new events since the last time
+func (c *leaderCheckpointer) save(ctx context.Context) {
+	lastEventTime := getLastProcessedEventTime()
+	if lastEventTime.IsZero() || !lastEventTime.After(c.lastSaved) {
+		return
+	}
+	// The events that have an EventTime but no LastTimestamp have microseconds
+	checkpointRaw := lastEventTime.UTC().Format(time.RFC3339Nano)
+
+	configMaps := c.clientset.CoreV1().ConfigMaps(c.config.namespace)
+	patch := fmt.Sprintf(`{"data":{%q:%q}}`, leaderCheckpointKey, checkpointRaw)
+	_, err := configMaps.Patch(ctx, c.configMapName(), types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.configMapName(),
+				Namespace: c.config.namespace,
+			},
+			Data: map[string]string{leaderCheckpointKey: checkpointRaw},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Msgf("Cannot save the leader checkpoint: %v", err)
+		return
+	}
+	c.lastSaved = lastEventTime
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunWithLeaderElectionCheckpoint(t *testing.T) {
+	defer func() {
+		eventsCheckpoint.lastEventTime = time.Time{}
+		eventsCheckpoint.resumeFrom = time.Time{}
+	}()
+
+	checkpoint := time.Date(2023, 11, 7, 10, 0, 0, 125000000, time.UTC)
+	checkpointObj := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestLeaderElectionLease-checkpoint",
+			Namespace: "TestLeaderElectionNamespace",
+		},
+		Data: map[string]string{leaderCheckpointKey: checkpoint.Format(time.RFC3339Nano)},
+	}
+	fakeClientset := fake.NewSimpleClientset(checkpointObj)
+	config := &leaderElectionConfig{
+		namespace:     "TestLeaderElectionNamespace",
+		leaseName:     "TestLeaderElectionLease",
+		identity:      "TestLeaderElectionReplica",
+		leaseDuration: 3 * time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leading := make(chan struct{})
+	processedEventTime := checkpoint.Add(time.Minute + 250*time.Microsecond)
+	run := func(leaderCtx context.Context) {
+		close(leading)
+		recordProcessedEventTime(processedEventTime)
+		<-leaderCtx.Done()
+	}
+
+	electionDone := make(chan error)
+	go func() {
+		electionDone <- runWithLeaderElection(ctx, fakeClientset, config, run)
+	}()
+
+	select {
+	case <-leading:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The replica did not acquire the leadership")
+	}
+	// The events of the second of the checkpoint are watched again
+	if resumeFrom := getEventsResumeTime(); !resumeFrom.Equal(checkpoint.Add(-time.Second)) {
+		t.Errorf("Resume time expected: %s, actual: %s", checkpoint.Add(-time.Second), resumeFrom)
+	}
+
+	cancel()
+	select {
+	case err := <-electionDone:
+		if err != nil {
+			t.Errorf("Leader election failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The leader election did not stop")
+	}
+
+	// The last processed event is stored when the leadership ends
+	checkpointObj, err := fakeClientset.CoreV1().ConfigMaps(config.namespace).Get(context.TODO(), checkpointObj.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Cannot get the checkpoint: %v", err)
+	}
+	expected := processedEventTime.Format(time.RFC3339Nano)
+	if checkpointObj.Data[leaderCheckpointKey] != expected {
+		t.Errorf("Checkpoint expected: %s, actual: %s", expected, checkpointObj.Data[leaderCheckpointKey])
+	}
+
+	// The lease was released for a fast failover
+	lease, err := fakeClientset.CoordinationV1().Leases(config.namespace).Get(context.TODO(), config.leaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Cannot get the lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != "" {
+		t.Errorf("The lease was not released, holder: %s", *lease.Spec.HolderIdentity)
+	}
+}
@@ -3,13 +3,15 @@ package main
 import (
 	"context"
 	"os"
+	"os/signal"
 	"strings"
-	"time"
+	"sync"
+	"syscall"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/rs/zerolog"
 	globalLogger "github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
 func configureLogging() {
@@ -37,10 +39,30 @@ func configureLogging() {
 
 func main() {
 	configureLogging()
+	if err := initSelfMonitoring(); err != nil {
+		globalLogger.Fatal().Msgf("Self-monitoring init error: %s", err)
+	}
+	if err := loadAgentConfig(); err != nil {
+		globalLogger.Fatal().Msgf("Agent config error: %s", err)
+	}
 	initSentrySDK()
-	defer sentry.Flush(time.Second)
 	checkCommonEnhancerPatterns()
 	prepareEventFilters()
+	if err := prepareSamplingRules(); err != nil {
+		globalLogger.Fatal().Msgf("Cannot prepare sampling rules: %s", err)
+	}
+	if err := prepareMuteWindows(); err != nil {
+		globalLogger.Fatal().Msgf("Cannot prepare mute windows: %s", err)
+	}
+	if err := preparePlatformDestinations(); err != nil {
+		globalLogger.Fatal().Msgf("Cannot prepare platform destinations: %s", err)
+	}
+	if err := prepareRoutingRules(); err != nil {
+		globalLogger.Fatal().Msgf("Cannot prepare routing rules: %s", err)
+	}
+	if err := prepareCronsConfig(); err != nil {
+		globalLogger.Fatal().Msgf("Cannot prepare the crons config: %s", err)
+	}
 
 	config, err := getClusterConfig()
 	if err != nil {
@@ -63,10 +85,57 @@ func main() {
 		namespaces = []string{v1.NamespaceAll}
 	}
 
-	ctx := globalLogger.Logger.WithContext(context.Background())
-	startEventWatchers(ctx, config, namespaces)
-	startPodWatchers(ctx, config, namespaces)
+	// The context is cancelled on SIGINT/SIGTERM, which stops the watchers and informers
+	ctx, stop := signal.NotifyContext(globalLogger.Logger.WithContext(context.Background()), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go dsnClientMapping.runIdleClientEviction(ctx)
+	go runSelfHeartbeat(ctx)
+	if httpAddress := getHTTPAddress(); httpAddress != "" {
+		go runHTTPServer(ctx, httpAddress)
+	}
+
+	startWatchers := func(ctx context.Context, namespaces []string, wg *sync.WaitGroup) {
+		startEventWatchers(ctx, config, namespaces, wg)
+		startPodWatchers(ctx, config, namespaces, wg)
+	}
+	// Watches and reports until the context is cancelled
+	run := func(ctx context.Context) {
+		var wg sync.WaitGroup
+		startWatchers(ctx, namespaces, &wg)
+		<-ctx.Done()
+		shutdown(&wg)
+	}
+
+	if !isLeaderElectionEnabled() && !isShardingEnabled() {
+		run(ctx)
+		return
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		globalLogger.Fatal().Msgf("Cannot create a clientset for coordination: %s", err)
+	}
 
-	// Sleep forever
-	select {}
+	if isShardingEnabled() {
+		if isLeaderElectionEnabled() {
+			globalLogger.Fatal().Msg("Leader election and sharding cannot be enabled at the same time")
+		}
+		// The work is spread across all the replicas
+		shardingConfig, err := getShardingConfig()
+		if err != nil {
+			globalLogger.Fatal().Msgf("Sharding config error: %s", err)
+		}
+		runWithSharding(ctx, clientset, shardingConfig, namespaces, startWatchers)
+		return
+	}
+
+	// Only the leader watches and reports
+	leaderElectionConfig, err := getLeaderElectionConfig()
+	if err != nil {
+		globalLogger.Fatal().Msgf("Leader election config error: %s", err)
+	}
+	if err := runWithLeaderElection(ctx, clientset, leaderElectionConfig, run); err != nil {
+		globalLogger.Fatal().Msgf("Leader election error: %s", err)
+	}
 }
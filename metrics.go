@@ -0,0 +1,208 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const metricsNamespace = "sentry_k8s"
+
+// Names of the filters that can skip an event, used as metric labels
+const (
+	FilterShard       = "shard"
+	FilterTooOld      = "too_old"
+	FilterEventType   = "event_type"
+	FilterReason      = "reason"
+	FilterEventSource = "event_source"
+	FilterDeletedPod  = "deleted_pod"
+	FilterAnnotation  = "annotation"
+	FilterMute        = "mute"
+	FilterSampling    = "sampling"
+)
+
+// Where an object was found by findObject, used as metric labels
+const (
+	ObjectLookupCache    = "cache"
+	ObjectLookupInformer = "informer"
+	ObjectLookupAPI      = "api"
+)
+
+// The reasons that are kept in the reason label of the filtered events: the
+// reasons of the events of the Kubernetes components and of the terminated
+// containers. Other reasons are free-form, they are counted as "other" to keep
+// the number of series bounded.
+var metricEventReasons = map[string]struct{}{
+	"BackOff": {}, "BackoffLimitExceeded": {}, "Completed": {}, "ContainerCannotRun": {},
+	"ContainerdStart": {}, "Created": {}, "DeadlineExceeded": {}, "DockerStart": {},
+	"Error": {}, "Evicted": {}, "Failed": {}, "FailedAttachVolume": {},
+	"FailedCreate": {}, "FailedCreatePodSandBox": {}, "FailedKillPod": {}, "FailedMount": {},
+	"FailedScheduling": {}, "FailedSync": {}, "Killing": {}, "KubeletStart": {},
+	"NodeNotReady": {}, "NodeReady": {}, "NodeSysctlChange": {}, "OOMKilled": {},
+	"OOMKilling": {}, "Preempting": {}, "ProbeWarning": {}, "Pulled": {},
+	"Pulling": {}, "Rebooted": {}, "SawCompletedJob": {}, "ScalingReplicaSet": {},
+	"Scheduled": {}, "Started": {}, "SuccessfulCreate": {}, "SuccessfulDelete": {},
+	"SystemOOM": {}, "Unhealthy": {},
+}
+
+const otherEventReason = "other"
+
+// The agent's own metrics are kept in a separate registry,
+// so that only the metrics registered here are exposed
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	eventsReceivedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Events (or pod updates) received from the watchers.",
+	}, []string{"watcher", "namespace"})
+
+	eventsFilteredCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_filtered_total",
+		Help:      "Events that were not reported, by the filter that skipped them.",
+	}, []string{"watcher", "namespace", "filter", "reason"})
+
+	eventsMutedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_muted_total",
+		Help:      "Events suppressed by mute windows, by the name of the window and its action.",
+	}, []string{"mute_window", "action"})
+
+	eventsCapturedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_captured_total",
+		Help:      "Events captured by the Sentry clients, counted once per destination.",
+	}, []string{"watcher", "namespace"})
+
+	eventsFailedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_failed_total",
+		Help:      "Events that the Sentry clients did not capture (e.g. dropped by the client), counted once per destination.",
+	}, []string{"watcher", "namespace"})
+
+	eventProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_processing_duration_seconds",
+		Help:      "Time spent processing a received event, including the filtered ones.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"watcher"})
+
+	watchRestartsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "watch_restarts_total",
+		Help:      "Times a watcher was restarted after its watch ended.",
+	}, []string{"watcher", "namespace"})
+
+	enhancerErrorsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "enhancer_errors_total",
+		Help:      "Errors returned by the event enhancers, by the kind of the involved object.",
+	}, []string{"kind"})
+
+	objectLookupsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "object_lookups_total",
+		Help:      "Object lookups, by where the object was found: cache, informer or api.",
+	}, []string{"kind", "source"})
+
+	cronsCheckinsCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "crons_checkins_total",
+		Help:      "Sentry Crons check-ins sent, by status.",
+	}, []string{"status"})
+
+	dsnClientPoolSizeGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dsn_client_pool_size",
+		Help:      "Sentry clients in the pool of custom DSN clients.",
+	}, func() float64 {
+		return float64(dsnClientMapping.Size())
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		eventsReceivedCounter,
+		eventsFilteredCounter,
+		eventsMutedCounter,
+		eventsCapturedCounter,
+		eventsFailedCounter,
+		eventProcessingDuration,
+		watchRestartsCounter,
+		enhancerErrorsCounter,
+		objectLookupsCounter,
+		cronsCheckinsCounter,
+		dsnClientPoolSizeGauge,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+func countReceivedEvent(watcher string, namespace string) {
+	eventsReceivedCounter.WithLabelValues(watcher, namespace).Inc()
+}
+
+func countFilteredEvent(params *samplingParams, filter string) {
+	eventsFilteredCounter.WithLabelValues(params.watcher, params.namespace, filter, getMetricEventReason(params.reason)).Inc()
+}
+
+// Returns the reason label of an event: one of the known reasons, or "other"
+func getMetricEventReason(reason string) string {
+	if _, ok := metricEventReasons[reason]; ok {
+		return reason
+	}
+	return otherEventReason
+}
+
+// Counts an event suppressed by the mute window, returns the
+// number of events suppressed by the window with this action so far
+func countMutedEvent(window string, action string) uint64 {
+	counter := eventsMutedCounter.WithLabelValues(window, action)
+	counter.Inc()
+	metric := &dto.Metric{}
+	if err := counter.Write(metric); err != nil {
+		return 0
+	}
+	return uint64(metric.GetCounter().GetValue())
+}
+
+// Counts the result of capturing an event: the client returns
+// no event ID if the event was not captured
+func countCapturedEvent(params *samplingParams, eventID *sentry.EventID) {
+	if eventID == nil {
+		eventsFailedCounter.WithLabelValues(params.watcher, params.namespace).Inc()
+		return
+	}
+	eventsCapturedCounter.WithLabelValues(params.watcher, params.namespace).Inc()
+}
+
+func observeEventProcessing(watcher string, start time.Time) {
+	eventProcessingDuration.WithLabelValues(watcher).Observe(time.Since(start).Seconds())
+}
+
+func countWatchRestart(watcher string, namespace string) {
+	watchRestartsCounter.WithLabelValues(watcher, namespace).Inc()
+}
+
+func countEnhancerError(kind string) {
+	enhancerErrorsCounter.WithLabelValues(kind).Inc()
+}
+
+func countObjectLookup(kind string, source string) {
+	objectLookupsCounter.WithLabelValues(kind, source).Inc()
+}
+
+func countCronsCheckin(status sentry.CheckInStatus) {
+	cronsCheckinsCounter.WithLabelValues(string(status)).Inc()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func TestHandleWatchEventMetrics(t *testing.T) {
+	transport := &TransportMock{}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport: transport,
+		Integrations: func([]sentry.Integration) []sentry.Integration {
+			return []sentry.Integration{}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := sentry.SetHubOnContext(context.Background(), sentry.NewHub(client, sentry.NewScope()))
+
+	namespace := "TestHandleWatchEventMetricsNamespace"
+	newEvent := func(eventType string, reason string) *watch.Event {
+		return &watch.Event{
+			Type: watch.Added,
+			Object: &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: "TestHandleWatchEventMetricsEvent", Namespace: namespace},
+				InvolvedObject: corev1.ObjectReference{
+					Kind:      "Pod",
+					Name:      "TestHandleWatchEventMetricsPod",
+					Namespace: namespace,
+				},
+				Reason:        reason,
+				Message:       "Fake Message: TestHandleWatchEventMetrics",
+				LastTimestamp: metav1.NewTime(time.Now()),
+				Type:          eventType,
+			},
+		}
+	}
+
+	handleWatchEvent(ctx, newEvent(corev1.EventTypeNormal, "Pulled"), metav1.Time{})
+	handleWatchEvent(ctx, newEvent(corev1.EventTypeNormal, "CustomReason-12345"), metav1.Time{})
+	handleWatchEvent(ctx, newEvent(corev1.EventTypeWarning, "BackOff"), metav1.Time{})
+
+	if received := testutil.ToFloat64(eventsReceivedCounter.WithLabelValues(eventsWatcherName, namespace)); received != 3 {
+		t.Errorf("Received events expected: 3, actual: %v", received)
+	}
+	if filtered := testutil.ToFloat64(eventsFilteredCounter.WithLabelValues(eventsWatcherName, namespace, FilterEventType, "Pulled")); filtered != 1 {
+		t.Errorf("Filtered events expected: 1, actual: %v", filtered)
+	}
+	// Free-form reasons do not create new series
+	if filtered := testutil.ToFloat64(eventsFilteredCounter.WithLabelValues(eventsWatcherName, namespace, FilterEventType, otherEventReason)); filtered != 1 {
+		t.Errorf("Filtered events with other reasons expected: 1, actual: %v", filtered)
+	}
+	if captured := testutil.ToFloat64(eventsCapturedCounter.WithLabelValues(eventsWatcherName, namespace)); captured != 1 {
+		t.Errorf("Captured events expected: 1, actual: %v", captured)
+	}
+	if failed := testutil.ToFloat64(eventsFailedCounter.WithLabelValues(eventsWatcherName, namespace)); failed != 0 {
+		t.Errorf("Failed events expected: 0, actual: %v", failed)
+	}
+	if len(transport.events) != 1 {
+		t.Errorf("Sent events expected: 1, actual: %d", len(transport.events))
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	countCronsCheckin(sentry.CheckInStatusOK)
+
+	server := httptest.NewServer(newHTTPServeMux())
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`sentry_k8s_crons_checkins_total{status="ok"}`,
+		"sentry_k8s_dsn_client_pool_size",
+		"go_goroutines",
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("The metrics do not contain %q", expected)
+		}
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
 	"sync"
+	"testing"
 	"time"
 
 	"github.com/getsentry/sentry-go"
 )
 
+// Binds the client to the current hub until the end of the test, as the
+// agent's main client that the clients of the other DSNs are created from
+func setMainTestClient(t *testing.T, client *sentry.Client) {
+	hub := sentry.CurrentHub()
+	oldClient := hub.Client()
+	hub.BindClient(client)
+	t.Cleanup(func() { hub.BindClient(oldClient) })
+}
+
 type TransportMock struct {
 	mu     sync.Mutex
 	events []*sentry.Event
@@ -21,6 +32,10 @@ func (t *TransportMock) SendEvent(event *sentry.Event) {
 func (t *TransportMock) Flush(timeout time.Duration) bool {
 	return true
 }
+func (t *TransportMock) FlushWithContext(ctx context.Context) bool {
+	return true
+}
+func (t *TransportMock) Close() {}
 func (t *TransportMock) Events() []*sentry.Event {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	MuteUntilAnnotation  = "k8s.sentry.io/mute-until"
+	MuteActionAnnotation = "k8s.sentry.io/mute-action"
+)
+
+type MuteAction string
+
+const (
+	// Matching events are dropped
+	MuteActionDrop MuteAction = "drop"
+	// Matching events are recorded as breadcrumbs for later events
+	MuteActionBreadcrumb MuteAction = "breadcrumb"
+)
+
+// A mute window is either a fixed time range (start/end), or a recurring
+// window that opens on a cron schedule and stays open for the given duration.
+// Windows can be scoped by namespace and node name (glob patterns), and by a
+// label selector evaluated against the involved object.
+type MuteWindow struct {
+	Name          string     `json:"name,omitempty"`
+	Start         *time.Time `json:"start,omitempty"`
+	End           *time.Time `json:"end,omitempty"`
+	Schedule      string     `json:"schedule,omitempty"`
+	Timezone      string     `json:"timezone,omitempty"`
+	Duration      string     `json:"duration,omitempty"`
+	Namespaces    []string   `json:"namespaces,omitempty"`
+	Nodes         []string   `json:"nodes,omitempty"`
+	LabelSelector string     `json:"labelSelector,omitempty"`
+	Action        MuteAction `json:"action,omitempty"`
+
+	schedule cron.Schedule
+	duration time.Duration
+	selector labels.Selector
+}
+
+// The attributes of an event that mute windows are matched against
+type muteParams struct {
+	namespace    string
+	nodeName     string
+	objectLabels map[string]string
+	now          time.Time
+}
+
+// Describes why an event is muted
+type activeMute struct {
+	name   string
+	action MuteAction
+}
+
+func parseMuteAction(action string) (MuteAction, error) {
+	switch MuteAction(strings.ToLower(strings.TrimSpace(action))) {
+	case "", MuteActionDrop:
+		return MuteActionDrop, nil
+	case MuteActionBreadcrumb:
+		return MuteActionBreadcrumb, nil
+	default:
+		return "", fmt.Errorf("unknown mute action: %q", action)
+	}
+}
+
+func prepareMuteWindows() error {
+	for i, window := range agentConfig.MuteWindows {
+		if window.Name == "" {
+			window.Name = fmt.Sprintf("mute-window-%d", i)
+		}
+
+		action, err := parseMuteAction(string(window.Action))
+		if err != nil {
+			return fmt.Errorf("mute window %q: %v", window.Name, err)
+		}
+		window.Action = action
+
+		if window.Schedule != "" {
+			if window.Start != nil || window.End != nil {
+				return fmt.Errorf("mute window %q: schedule cannot be combined with start/end", window.Name)
+			}
+			spec := window.Schedule
+			if window.Timezone != "" {
+				spec = fmt.Sprintf("CRON_TZ=%s %s", window.Timezone, spec)
+			}
+			window.schedule, err = cron.ParseStandard(spec)
+			if err != nil {
+				return fmt.Errorf("mute window %q: invalid schedule: %v", window.Name, err)
+			}
+			window.duration, err = time.ParseDuration(window.Duration)
+			if err != nil || window.duration <= 0 {
+				return fmt.Errorf("mute window %q: a positive duration is required with schedule", window.Name)
+			}
+		} else if window.Start == nil && window.End == nil {
+			return fmt.Errorf("mute window %q: either schedule or start/end is required", window.Name)
+		}
+
+		if window.LabelSelector != "" {
+			window.selector, err = labels.Parse(window.LabelSelector)
+			if err != nil {
+				return fmt.Errorf("mute window %q: invalid label selector: %v", window.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *MuteWindow) isOpen(now time.Time) bool {
+	if w.schedule != nil {
+		// The window is open if it was (re)opened within the last "duration"
+		lastOpened := w.schedule.Next(now.Add(-w.duration))
+		return !lastOpened.After(now)
+	}
+	if w.Start != nil && now.Before(*w.Start) {
+		return false
+	}
+	if w.End != nil && !now.Before(*w.End) {
+		return false
+	}
+	return true
+}
+
+func (w *MuteWindow) matches(params *muteParams) bool {
+	if !w.isOpen(params.now) {
+		return false
+	}
+	if len(w.Namespaces) > 0 && !matchesAnyPattern(w.Namespaces, params.namespace) {
+		return false
+	}
+	if len(w.Nodes) > 0 && !matchesAnyPattern(w.Nodes, params.nodeName) {
+		return false
+	}
+	if w.selector != nil && !w.selector.Matches(labels.Set(params.objectLabels)) {
+		return false
+	}
+	return true
+}
+
+// Returns the mute set via annotations on the cluster-scoped object, if any
+func getAnnotationMute(ctx context.Context, kind string, name string, now time.Time) *activeMute {
+	object, found := findClusterObjectCached(ctx, kind, name)
+	if !found {
+		return nil
+	}
+	annotations := object.GetAnnotations()
+	rawUntil, ok := annotations[MuteUntilAnnotation]
+	if !ok {
+		return nil
+	}
+
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(rawUntil))
+	action, actionErr := parseMuteAction(annotations[MuteActionAnnotation])
+	if err != nil || actionErr != nil {
+		zerolog.Ctx(ctx).Warn().Msgf("Invalid mute annotations on %s %q", kind, name)
+		return nil
+	}
+	if !now.Before(until) {
+		return nil
+	}
+	return &activeMute{
+		name:   fmt.Sprintf("annotation on %s %q", kind, name),
+		action: action,
+	}
+}
+
+// Returns the mute window that applies to the event, if any
+func findActiveMute(ctx context.Context, params *muteParams) (*activeMute, bool) {
+	for _, window := range agentConfig.MuteWindows {
+		if window.matches(params) {
+			return &activeMute{name: window.Name, action: window.Action}, true
+		}
+	}
+	if mute := getAnnotationMute(ctx, KindNamespace, params.namespace, params.now); mute != nil {
+		return mute, true
+	}
+	if mute := getAnnotationMute(ctx, KindNode, params.nodeName, params.now); mute != nil {
+		return mute, true
+	}
+	return nil, false
+}
+
+// Returns true if the event is muted, in which case it was either dropped
+// or recorded as a breadcrumb on the watcher's hub, and should not be captured
+func suppressIfMuted(ctx context.Context, params *muteParams, message string) bool {
+	if params.now.IsZero() {
+		params.now = time.Now()
+	}
+	mute, ok := findActiveMute(ctx, params)
+	if !ok {
+		return false
+	}
+
+	logger := zerolog.Ctx(ctx)
+	count := countMutedEvent(mute.name, string(mute.action))
+	logger.Info().Msgf(
+		"Event suppressed by mute window %q (action: %s, suppressed so far: %d): %s",
+		mute.name, mute.action, count, message,
+	)
+
+	if mute.action == MuteActionBreadcrumb {
+		if hub := sentry.GetHubFromContext(ctx); hub != nil {
+			hub.Scope().AddBreadcrumb(&sentry.Breadcrumb{
+				Category: "muted",
+				Message:  message,
+				Level:    sentry.LevelWarning,
+				Data: map[string]interface{}{
+					"mute_window": mute.name,
+					"namespace":   params.namespace,
+					"node_name":   params.nodeName,
+				},
+				Timestamp: params.now,
+			}, breadcrumbLimit)
+		}
+	}
+	return true
+}
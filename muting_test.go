@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMuteWindowMatches(t *testing.T) {
+	config, err := parseAgentConfig([]byte(`
+muteWindows:
+  - name: node-upgrades
+    schedule: "0 2 * * SAT"
+    timezone: UTC
+    duration: 2h
+    nodes: ["gke-pool-1-*"]
+    action: breadcrumb
+  - name: chaos-test
+    start: 2023-11-15T10:00:00Z
+    end: 2023-11-15T12:00:00Z
+    namespaces: ["chaos-*"]
+    labelSelector: "app=frontend"
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse the agent config: %v", err)
+	}
+
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = config
+
+	if err := prepareMuteWindows(); err != nil {
+		t.Fatalf("Failed to prepare mute windows: %v", err)
+	}
+
+	nodeUpgrades, chaosTest := config.MuteWindows[0], config.MuteWindows[1]
+	if nodeUpgrades.Action != MuteActionBreadcrumb || chaosTest.Action != MuteActionDrop {
+		t.Errorf("Mute actions are parsed incorrectly")
+	}
+
+	// Saturday, 2023-11-18
+	saturday := time.Date(2023, 11, 18, 3, 30, 0, 0, time.UTC)
+	testCases := []struct {
+		window   *MuteWindow
+		params   muteParams
+		expected bool
+	}{
+		{nodeUpgrades, muteParams{nodeName: "gke-pool-1-abc", now: saturday}, true},
+		{nodeUpgrades, muteParams{nodeName: "gke-pool-2-abc", now: saturday}, false},
+		{nodeUpgrades, muteParams{nodeName: "gke-pool-1-abc", now: saturday.Add(time.Hour)}, false},
+		{nodeUpgrades, muteParams{nodeName: "gke-pool-1-abc", now: saturday.Add(-24 * time.Hour)}, false},
+		{
+			chaosTest,
+			muteParams{namespace: "chaos-1", objectLabels: map[string]string{"app": "frontend"}, now: time.Date(2023, 11, 15, 11, 0, 0, 0, time.UTC)},
+			true,
+		},
+		{
+			chaosTest,
+			muteParams{namespace: "chaos-1", objectLabels: map[string]string{"app": "backend"}, now: time.Date(2023, 11, 15, 11, 0, 0, 0, time.UTC)},
+			false,
+		},
+		{
+			chaosTest,
+			muteParams{namespace: "chaos-1", objectLabels: map[string]string{"app": "frontend"}, now: time.Date(2023, 11, 15, 12, 0, 0, 0, time.UTC)},
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		if matched := tc.window.matches(&tc.params); matched != tc.expected {
+			t.Errorf("Window %q, params %+v: received %v, wanted %v", tc.window.Name, tc.params, matched, tc.expected)
+		}
+	}
+}
+
+func TestPrepareMuteWindowsInvalid(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+
+	invalidConfigs := []string{
+		"muteWindows: [{name: a}]",
+		"muteWindows: [{name: a, schedule: '0 2 * * *'}]",
+		"muteWindows: [{name: a, schedule: 'invalid', duration: 1h}]",
+		"muteWindows: [{name: a, start: 2023-11-15T10:00:00Z, action: unknown}]",
+		"muteWindows: [{name: a, start: 2023-11-15T10:00:00Z, labelSelector: '!!'}]",
+	}
+	for _, rawConfig := range invalidConfigs {
+		config, err := parseAgentConfig([]byte(rawConfig))
+		if err != nil {
+			t.Fatalf("Failed to parse the agent config: %v", err)
+		}
+		agentConfig = config
+		if err := prepareMuteWindows(); err == nil {
+			t.Errorf("Expected an error for the mute window config: %s", rawConfig)
+		}
+	}
+}
+
+func TestSuppressIfMutedByNamespaceAnnotation(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = &AgentConfig{}
+
+	now := time.Date(2023, 11, 15, 11, 0, 0, 0, time.UTC)
+	namespaceObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "TestSuppressIfMutedNamespace",
+			Annotations: map[string]string{
+				MuteUntilAnnotation:  now.Add(time.Hour).Format(time.RFC3339),
+				MuteActionAnnotation: "breadcrumb",
+			},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(namespaceObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	hub := sentry.NewHub(nil, sentry.NewScope())
+	ctx = sentry.SetHubOnContext(ctx, hub)
+
+	params := &muteParams{namespace: "TestSuppressIfMutedNamespace", now: now}
+	if !suppressIfMuted(ctx, params, "Fake Message: TestSuppressIfMuted") {
+		t.Errorf("The event should be muted by the namespace annotation")
+	}
+
+	event := hub.Scope().ApplyToEvent(&sentry.Event{}, nil, nil)
+	if len(event.Breadcrumbs) != 1 || event.Breadcrumbs[0].Message != "Fake Message: TestSuppressIfMuted" {
+		t.Errorf("The muted event should be recorded as a breadcrumb, got: %v", event.Breadcrumbs)
+	}
+	muted := testutil.ToFloat64(eventsMutedCounter.WithLabelValues(`annotation on Namespace "TestSuppressIfMutedNamespace"`, "breadcrumb"))
+	if muted != 1 {
+		t.Errorf("Muted events expected: 1, actual: %v", muted)
+	}
+
+	params = &muteParams{namespace: "TestSuppressIfMutedNamespace", now: now.Add(2 * time.Hour)}
+	if suppressIfMuted(ctx, params, "Fake Message: TestSuppressIfMuted") {
+		t.Errorf("The event should not be muted after the annotation expires")
+	}
+}
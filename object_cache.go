@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Cluster-scoped objects (namespaces, nodes) are looked up for many events,
+// so they are cached for a short time to avoid hitting the API every time
+const clusterObjectCacheTTL = time.Minute
+
+type cachedClusterObject struct {
+	object    metav1.Object
+	found     bool
+	fetchedAt time.Time
+}
+
+var clusterObjectCache = struct {
+	mutex   sync.Mutex
+	entries map[string]*cachedClusterObject
+}{entries: make(map[string]*cachedClusterObject)}
+
+func findClusterObjectCached(ctx context.Context, kind string, name string) (metav1.Object, bool) {
+	if name == "" {
+		return nil, false
+	}
+	key := kind + "/" + name
+
+	clusterObjectCache.mutex.Lock()
+	entry, ok := clusterObjectCache.entries[key]
+	clusterObjectCache.mutex.Unlock()
+
+	if ok && time.Since(entry.fetchedAt) <= clusterObjectCacheTTL {
+		countObjectLookup(kind, ObjectLookupCache)
+		return entry.object, entry.found
+	}
+
+	object, found := findObject(ctx, kind, "", name)
+	clusterObjectCache.mutex.Lock()
+	clusterObjectCache.entries[key] = &cachedClusterObject{
+		object:    object,
+		found:     found,
+		fetchedAt: time.Now(),
+	}
+	clusterObjectCache.mutex.Unlock()
+	return object, found
+}
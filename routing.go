@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// A routing rule decides where the events from matching namespaces are sent.
+// Namespaces are matched by name (glob patterns) and/or by a label selector
+// evaluated against the Namespace object. The first matching rule wins.
+type RoutingRule struct {
+	Namespaces        []string          `json:"namespaces,omitempty"`
+	NamespaceSelector string            `json:"namespaceSelector,omitempty"`
+	Dsn               string            `json:"dsn,omitempty"`
+	Dsns              []string          `json:"dsns,omitempty"`
+	Environment       string            `json:"environment,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+
+	selector labels.Selector
+}
+
+func prepareRoutingRules() error {
+	for i, rule := range agentConfig.Routing {
+		if len(rule.Namespaces) == 0 && rule.NamespaceSelector == "" {
+			return fmt.Errorf("routing rule #%d: namespaces or namespaceSelector is required", i)
+		}
+		if rule.NamespaceSelector != "" {
+			selector, err := labels.Parse(rule.NamespaceSelector)
+			if err != nil {
+				return fmt.Errorf("routing rule #%d: invalid namespace selector: %v", i, err)
+			}
+			rule.selector = selector
+		}
+	}
+	return nil
+}
+
+func (r *RoutingRule) matches(ctx context.Context, namespace string) bool {
+	if len(r.Namespaces) > 0 && !matchesAnyPattern(r.Namespaces, namespace) {
+		return false
+	}
+	if r.selector != nil {
+		namespaceObj, found := findClusterObjectCached(ctx, KindNamespace, namespace)
+		if !found || !r.selector.Matches(labels.Set(namespaceObj.GetLabels())) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the first routing rule that matches the namespace, or nil
+func findRoutingRule(ctx context.Context, namespace string) *RoutingRule {
+	if namespace == "" {
+		return nil
+	}
+	for _, rule := range agentConfig.Routing {
+		if rule.matches(ctx, namespace) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Returns the clients for the rule's DSNs, if any
+func (r *RoutingRule) getClients(ctx context.Context, clientOptions sentry.ClientOptions) []*sentry.Client {
+	if r == nil {
+		return nil
+	}
+	clients := []*sentry.Client{}
+	for _, dsn := range append(splitDsnList(r.Dsn), r.Dsns...) {
+		client, err := dsnClientMapping.GetOrCreateClient(dsn, clientOptions)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Msgf("Cannot create a client for the routing rule: %v", err)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Sets the rule's tags on the scope
+func (r *RoutingRule) applyToScope(scope *sentry.Scope) {
+	if r == nil {
+		return
+	}
+	for key, value := range r.Tags {
+		setTagIfNotEmpty(scope, key, value)
+	}
+}
+
+func (r *RoutingRule) applyToEvent(sentryEvent *sentry.Event) {
+	if r == nil {
+		return
+	}
+	if r.Environment != "" {
+		sentryEvent.Environment = r.Environment
+	}
+}
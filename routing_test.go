@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindRoutingRule(t *testing.T) {
+	config, err := parseAgentConfig([]byte(`
+routing:
+  - namespaces: ["staging-*"]
+    environment: staging
+    tags:
+      tier: staging
+  - namespaceSelector: "env=production"
+    environment: production
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse the agent config: %v", err)
+	}
+
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = config
+
+	if err := prepareRoutingRules(); err != nil {
+		t.Fatalf("Failed to prepare routing rules: %v", err)
+	}
+
+	namespaceObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "TestFindRoutingRuleNamespace",
+			Labels: map[string]string{"env": "production"},
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(namespaceObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	route := findRoutingRule(ctx, "staging-payments")
+	if route != config.Routing[0] {
+		t.Errorf("The staging namespace should match the first rule")
+	}
+
+	route = findRoutingRule(ctx, "TestFindRoutingRuleNamespace")
+	if route != config.Routing[1] {
+		t.Errorf("The labeled namespace should match the second rule")
+	}
+	sentryEvent := &sentry.Event{}
+	route.applyToEvent(sentryEvent)
+	if sentryEvent.Environment != "production" {
+		t.Errorf("Environment expected: %s, actual: %s", "production", sentryEvent.Environment)
+	}
+
+	route = findRoutingRule(ctx, "TestFindRoutingRuleUnknownNamespace")
+	if route != nil {
+		t.Errorf("The unknown namespace should not match any rule")
+	}
+	// No rule is a no-op
+	route.applyToEvent(sentryEvent)
+}
+
+func TestSearchDsnNamespaceFallback(t *testing.T) {
+	fakeDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567890"
+
+	namespaceObj := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "TestSearchDsnNamespaceFallback",
+			Annotations: map[string]string{DSNAnnotation: fakeDsn},
+		},
+	}
+	podObj := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "TestSearchDsnNamespaceFallbackPod",
+			Namespace: "TestSearchDsnNamespaceFallback",
+		},
+	}
+	fakeClientset := fake.NewSimpleClientset(namespaceObj, podObj)
+	ctx := setClientsetOnContext(context.Background(), fakeClientset)
+
+	dsn, err := searchDsn(ctx, podObj)
+	if err != nil {
+		t.Errorf("Failed to search for the DSN: %v", err)
+	}
+	if dsn != fakeDsn {
+		t.Errorf("DSN expected: %s, actual: %s", fakeDsn, dsn)
+	}
+}
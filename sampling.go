@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	globalLogger "github.com/rs/zerolog/log"
+)
+
+// A sampling rule applies to an event if all of its non-empty matchers
+// match the event. Reasons, event sources and watchers are compared
+// case-insensitively, namespaces support glob patterns.
+type SamplingRule struct {
+	Reasons      []string `json:"reasons,omitempty"`
+	Namespaces   []string `json:"namespaces,omitempty"`
+	EventSources []string `json:"eventSources,omitempty"`
+	Watchers     []string `json:"watchers,omitempty"`
+	Message      string   `json:"message,omitempty"`
+	SampleRate   *float64 `json:"sampleRate"`
+
+	messageRegex *regexp.Regexp
+}
+
+// The attributes of an event that sampling rules are matched against
+type samplingParams struct {
+	watcher     string
+	namespace   string
+	reason      string
+	eventSource string
+	message     string
+}
+
+// Sample rate for events that do not match any rule
+var defaultSampleRate = 1.0
+
+func prepareSamplingRules() error {
+	sampleRateRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_SAMPLE_RATE"))
+	if sampleRateRaw != "" {
+		sampleRate, err := strconv.ParseFloat(sampleRateRaw, 64)
+		if err != nil || sampleRate < 0 || sampleRate > 1 {
+			return fmt.Errorf("invalid sample rate in SENTRY_K8S_SAMPLE_RATE: %q", sampleRateRaw)
+		}
+		defaultSampleRate = sampleRate
+	}
+
+	for i, rule := range agentConfig.Sampling {
+		if rule.SampleRate == nil {
+			return fmt.Errorf("sampling rule #%d: sampleRate is required", i)
+		}
+		if *rule.SampleRate < 0 || *rule.SampleRate > 1 {
+			return fmt.Errorf("sampling rule #%d: sampleRate must be between 0 and 1", i)
+		}
+		if rule.Message != "" {
+			messageRegex, err := regexp.Compile(rule.Message)
+			if err != nil {
+				return fmt.Errorf("sampling rule #%d: invalid message pattern: %v", i, err)
+			}
+			rule.messageRegex = messageRegex
+		}
+	}
+	globalLogger.Debug().Msgf(
+		"Prepared %d sampling rule(s), default sample rate: %v",
+		len(agentConfig.Sampling),
+		defaultSampleRate,
+	)
+	return nil
+}
+
+func containsFold(values []string, value string) bool {
+	value = strings.TrimSpace(value)
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SamplingRule) matches(params *samplingParams) bool {
+	if len(r.Reasons) > 0 && !containsFold(r.Reasons, params.reason) {
+		return false
+	}
+	if len(r.Namespaces) > 0 && !matchesAnyPattern(r.Namespaces, params.namespace) {
+		return false
+	}
+	if len(r.EventSources) > 0 && !containsFold(r.EventSources, params.eventSource) {
+		return false
+	}
+	if len(r.Watchers) > 0 && !containsFold(r.Watchers, params.watcher) {
+		return false
+	}
+	if r.messageRegex != nil && !r.messageRegex.MatchString(params.message) {
+		return false
+	}
+	return true
+}
+
+// Returns the sample rate of the first matching rule,
+// or the default sample rate if no rule matches
+func getSampleRate(params *samplingParams) float64 {
+	for _, rule := range agentConfig.Sampling {
+		if rule.matches(params) {
+			return *rule.SampleRate
+		}
+	}
+	return defaultSampleRate
+}
+
+// Decides whether the event should be kept, and returns the applied sample rate
+func sampleEvent(params *samplingParams) (sampleRate float64, keep bool) {
+	sampleRate = getSampleRate(params)
+	if sampleRate >= 1 {
+		return sampleRate, true
+	}
+	return sampleRate, rand.Float64() < sampleRate
+}
+
+// Records the sample rate on the event, so event counts can be extrapolated
+func setSampleRateOnScope(scope *sentry.Scope, sampleRate float64) {
+	if sampleRate >= 1 {
+		return
+	}
+	sampleRateStr := strconv.FormatFloat(sampleRate, 'f', -1, 64)
+	scope.SetTag("sample_rate", sampleRateStr)
+	scope.SetContext("Sampling", sentry.Context{
+		"Sample rate": sampleRate,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetSampleRate(t *testing.T) {
+	config, err := parseAgentConfig([]byte(`
+sampling:
+  - reasons: ["Unhealthy"]
+    namespaces: ["dev-*"]
+    message: "^Readiness probe failed"
+    sampleRate: 0.1
+  - reasons: ["OOMKilled"]
+    sampleRate: 1
+  - watchers: ["pods"]
+    eventSources: ["x-pod-controller"]
+    sampleRate: 0.5
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse the agent config: %v", err)
+	}
+
+	oldConfig, oldDefaultSampleRate := agentConfig, defaultSampleRate
+	defer func() { agentConfig, defaultSampleRate = oldConfig, oldDefaultSampleRate }()
+	agentConfig = config
+
+	t.Setenv("SENTRY_K8S_SAMPLE_RATE", "0.75")
+	if err := prepareSamplingRules(); err != nil {
+		t.Fatalf("Failed to prepare sampling rules: %v", err)
+	}
+
+	testCases := []struct {
+		params   samplingParams
+		expected float64
+	}{
+		{
+			params:   samplingParams{watcher: "events", namespace: "dev-team", reason: "unhealthy", message: "Readiness probe failed: timeout"},
+			expected: 0.1,
+		},
+		{
+			params:   samplingParams{watcher: "events", namespace: "dev-team", reason: "Unhealthy", message: "Liveness probe failed: timeout"},
+			expected: 0.75,
+		},
+		{
+			params:   samplingParams{watcher: "events", namespace: "prod", reason: "Unhealthy", message: "Readiness probe failed: timeout"},
+			expected: 0.75,
+		},
+		{
+			params:   samplingParams{watcher: "pods", namespace: "dev-team", reason: "OOMKilled", eventSource: "x-pod-controller"},
+			expected: 1,
+		},
+		{
+			params:   samplingParams{watcher: "pods", namespace: "prod", reason: "Error", eventSource: "x-pod-controller"},
+			expected: 0.5,
+		},
+	}
+
+	for _, tc := range testCases {
+		sampleRate := getSampleRate(&tc.params)
+		if sampleRate != tc.expected {
+			t.Errorf("For %+v, received sample rate %v, wanted %v", tc.params, sampleRate, tc.expected)
+		}
+	}
+}
+
+func TestPrepareSamplingRulesInvalid(t *testing.T) {
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+
+	invalidConfigs := []string{
+		"sampling: [{reasons: [Unhealthy]}]",
+		"sampling: [{sampleRate: 1.5}]",
+		"sampling: [{message: '(', sampleRate: 0.5}]",
+	}
+	for _, rawConfig := range invalidConfigs {
+		config, err := parseAgentConfig([]byte(rawConfig))
+		if err != nil {
+			t.Fatalf("Failed to parse the agent config: %v", err)
+		}
+		agentConfig = config
+		if err := prepareSamplingRules(); err == nil {
+			t.Errorf("Expected an error for the sampling config: %s", rawConfig)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	globalLogger "github.com/rs/zerolog/log"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const defaultSelfHeartbeatInterval = time.Minute
+
+// Log messages of recovered panics start with this, the panics
+// themselves are reported as exceptions
+const recoveredPanicMessage = "Recovered from a panic"
+
+// The hub for the agent's own errors, nil if self-monitoring is disabled
+var selfMonitoringHub *sentry.Hub
+
+// Sets up the reporting of the agent's own errors (logged errors and panics)
+// to the project of SENTRY_K8S_SELF_DSN, separately from the cluster events
+func initSelfMonitoring() error {
+	dsn := strings.TrimSpace(os.Getenv("SENTRY_K8S_SELF_DSN"))
+	if dsn == "" {
+		return nil
+	}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:              dsn,
+		Release:          "sentry-kubernetes@" + version,
+		AttachStacktrace: true,
+		// Clear integration list
+		Integrations: func([]sentry.Integration) []sentry.Integration { return []sentry.Integration{} },
+	})
+	if err != nil {
+		return err
+	}
+	scope := sentry.NewScope()
+	if hostname, err := os.Hostname(); err == nil {
+		setTagIfNotEmpty(scope, "agent_hostname", hostname)
+	}
+	selfMonitoringHub = sentry.NewHub(client, scope)
+
+	// Loggers created afterwards (all the loggers on contexts) inherit the hook
+	globalLogger.Logger = globalLogger.Logger.Hook(selfMonitoringHook{})
+	// Panics in the informers' goroutines crash the agent, but are reported first
+	utilruntime.PanicHandlers = append(utilruntime.PanicHandlers, func(recovered interface{}) {
+		reportAgentPanic(recovered)
+	})
+
+	globalLogger.Info().Msg("Self-monitoring is enabled, the agent's own errors are reported to SENTRY_K8S_SELF_DSN")
+	return nil
+}
+
+// Reports the errors logged by the agent
+type selfMonitoringHook struct{}
+
+func (h selfMonitoringHook) Run(e *zerolog.Event, level zerolog.Level, message string) {
+	hub := selfMonitoringHub
+	if hub == nil || level < zerolog.ErrorLevel || level > zerolog.PanicLevel {
+		return
+	}
+	if strings.HasPrefix(message, recoveredPanicMessage) {
+		return
+	}
+
+	hub = hub.Clone()
+	hub.WithScope(func(scope *sentry.Scope) {
+		if level == zerolog.ErrorLevel {
+			scope.SetLevel(sentry.LevelError)
+		} else {
+			scope.SetLevel(sentry.LevelFatal)
+		}
+		hub.CaptureMessage(message)
+	})
+	// The agent exits after a fatal error
+	if level > zerolog.ErrorLevel {
+		hub.Flush(2 * time.Second)
+	}
+}
+
+func reportAgentPanic(recovered interface{}) {
+	hub := selfMonitoringHub
+	if hub == nil {
+		return
+	}
+	hub = hub.Clone()
+	hub.Recover(recovered)
+	hub.Flush(2 * time.Second)
+}
+
+// Runs the handler of a single event, so that a panic while processing
+// the event is reported, and does not stop the watcher
+func runRecovered(ctx context.Context, handler func()) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			zerolog.Ctx(ctx).Error().Msgf("%s while processing an event: %v", recoveredPanicMessage, recovered)
+			reportAgentPanic(recovered)
+		}
+	}()
+	handler()
+}
+
+// Deferred in the agent's goroutines: reports a panic, then crashes the
+// agent as usual (so that it is restarted)
+func reportPanicAndCrash() {
+	if recovered := recover(); recovered != nil {
+		reportAgentPanic(recovered)
+		panic(recovered)
+	}
+}
+
+func getSelfHeartbeatInterval() time.Duration {
+	intervalRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_SELF_HEARTBEAT_INTERVAL"))
+	if intervalRaw == "" {
+		return defaultSelfHeartbeatInterval
+	}
+	interval, err := time.ParseDuration(intervalRaw)
+	// Crons schedules are in minutes
+	if err != nil || interval < time.Minute {
+		globalLogger.Warn().Msgf("Invalid value of SENTRY_K8S_SELF_HEARTBEAT_INTERVAL: %q", intervalRaw)
+		return defaultSelfHeartbeatInterval
+	}
+	return interval.Truncate(time.Minute)
+}
+
+// Sends a check-in for the agent itself; the status
+// reflects the liveness of the watchers and informers
+func sendSelfHeartbeat(hub *sentry.Hub, monitorSlug string, interval time.Duration, healthTimeout time.Duration) {
+	status := sentry.CheckInStatusOK
+	if report := checkHealth(time.Now(), healthTimeout, false); len(report.Problems) > 0 {
+		status = sentry.CheckInStatusError
+	}
+	hub.CaptureCheckIn(
+		&sentry.CheckIn{
+			MonitorSlug: monitorSlug,
+			Status:      status,
+		},
+		&sentry.MonitorConfig{
+			Schedule:      sentry.IntervalSchedule(int64(interval/time.Minute), sentry.MonitorScheduleUnitMinute),
+			CheckInMargin: 1,
+		},
+	)
+}
+
+// Sends heartbeat check-ins to the SENTRY_K8S_SELF_MONITOR_SLUG monitor of the
+// self-monitoring project until the context is cancelled, so that Sentry
+// alerts when the agent stops
+func runSelfHeartbeat(ctx context.Context) {
+	defer reportPanicAndCrash()
+
+	monitorSlug := strings.TrimSpace(os.Getenv("SENTRY_K8S_SELF_MONITOR_SLUG"))
+	if selfMonitoringHub == nil || monitorSlug == "" {
+		return
+	}
+	interval := getSelfHeartbeatInterval()
+	healthTimeout := getHealthTimeout()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sendSelfHeartbeat(selfMonitoringHub, monitorSlug, interval, healthTimeout)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
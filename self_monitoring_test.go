@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+)
+
+func setSelfMonitoringHubForTest(t *testing.T) *TransportMock {
+	transport := &TransportMock{}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Transport:        transport,
+		AttachStacktrace: true,
+		Integrations: func([]sentry.Integration) []sentry.Integration {
+			return []sentry.Integration{}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfMonitoringHub = sentry.NewHub(client, sentry.NewScope())
+	return transport
+}
+
+func TestSelfMonitoringHook(t *testing.T) {
+	transport := setSelfMonitoringHubForTest(t)
+	defer func() { selfMonitoringHub = nil }()
+
+	logger := zerolog.New(io.Discard).Hook(selfMonitoringHook{})
+	logger.Warn().Msg("A warning is not reported")
+	logger.Error().Msgf("Cannot check in at the start of job %s", "default/backup-1")
+
+	if len(transport.events) != 1 {
+		t.Fatalf("Reported errors expected: 1, actual: %d", len(transport.events))
+	}
+	event := transport.events[0]
+	if event.Message != "Cannot check in at the start of job default/backup-1" {
+		t.Errorf("Unexpected message: %q", event.Message)
+	}
+	if event.Level != sentry.LevelError {
+		t.Errorf("Level expected: %s, actual: %s", sentry.LevelError, event.Level)
+	}
+}
+
+func TestRunRecovered(t *testing.T) {
+	transport := setSelfMonitoringHubForTest(t)
+	defer func() { selfMonitoringHub = nil }()
+
+	logger := zerolog.New(io.Discard).Hook(selfMonitoringHook{})
+	ctx := logger.WithContext(context.Background())
+
+	processed := false
+	runRecovered(ctx, func() {
+		var pod *struct{ name string }
+		_ = pod.name
+	})
+	runRecovered(ctx, func() {
+		processed = true
+	})
+
+	if !processed {
+		t.Errorf("The events after a panic should be processed")
+	}
+	// The panic is reported once, as an exception
+	if len(transport.events) != 1 {
+		t.Fatalf("Reported errors expected: 1, actual: %d", len(transport.events))
+	}
+	if exceptions := transport.events[0].Exception; len(exceptions) == 0 || exceptions[len(exceptions)-1].Stacktrace == nil {
+		t.Errorf("The panic should be reported as an exception with a stack trace: %#v", exceptions)
+	}
+}
+
+func TestSendSelfHeartbeat(t *testing.T) {
+	transport := setSelfMonitoringHubForTest(t)
+	defer func() { selfMonitoringHub = nil }()
+	resetAgentHealth()
+	defer resetAgentHealth()
+
+	sendSelfHeartbeat(selfMonitoringHub, "sentry-kubernetes-agent", 2*time.Minute, time.Minute)
+	setInformersState("", InformersStateFailed, io.EOF)
+	sendSelfHeartbeat(selfMonitoringHub, "sentry-kubernetes-agent", 2*time.Minute, time.Minute)
+
+	if len(transport.events) != 2 {
+		t.Fatalf("Check-ins expected: 2, actual: %d", len(transport.events))
+	}
+	for i, expectedStatus := range []sentry.CheckInStatus{sentry.CheckInStatusOK, sentry.CheckInStatusError} {
+		event := transport.events[i]
+		if event.CheckIn == nil || event.CheckIn.MonitorSlug != "sentry-kubernetes-agent" || event.CheckIn.Status != expectedStatus {
+			t.Errorf("Check-in #%d expected with status %s: %#v", i, expectedStatus, event.CheckIn)
+		}
+		if event.MonitorConfig == nil || event.MonitorConfig.Schedule == nil {
+			t.Errorf("Check-in #%d expected with a monitor config", i)
+		}
+	}
+}
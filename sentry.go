@@ -30,6 +30,8 @@ func initSentrySDK() {
 		Debug:         true,
 		EnableTracing: false,
 		BeforeSend:    beforeSend,
+		// The responses of Sentry are recorded for the readiness checks
+		HTTPTransport: sentryTransportHealth,
 		// Clear integration list
 		Integrations: func([]sentry.Integration) []sentry.Integration { return []sentry.Integration{} },
 	})
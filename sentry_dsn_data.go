@@ -2,118 +2,390 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	globalLogger "github.com/rs/zerolog/log"
 	v1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var DSNAnnotation = "k8s.sentry.io/dsn"
+
+// References a key in a secret from the same namespace that holds the DSN: "<secret name>/<key>"
+var DSNSecretAnnotation = "k8s.sentry.io/dsn-secret"
+
+const defaultDsnSecretKey = "dsn"
+
 var dsnClientMapping = NewDsnClientMapping()
 
+const (
+	defaultMaxDsnClients     = 100
+	defaultDsnClientIdleTime = time.Hour
+	dsnClientFlushTimeout    = 2 * time.Second
+)
+
+// Client options that can be overridden for a specific DSN
+type DsnClientOverride struct {
+	Dsn         string   `json:"dsn"`
+	Environment string   `json:"environment,omitempty"`
+	SampleRate  *float64 `json:"sampleRate,omitempty"`
+	Debug       *bool    `json:"debug,omitempty"`
+}
+
 // Map from Sentry DSN to Client
 type DsnClientMapping struct {
 	mutex         sync.RWMutex
 	clientMap     map[string]*sentry.Client
+	lastUsed      map[string]time.Time
 	customDsnFlag bool
+	// Maximum number of clients in the pool, 0 means no limit
+	maxClients int
+	// Clients that were not used for this long are evicted, 0 means never
+	idleTimeout time.Duration
 }
 
 func NewDsnClientMapping() *DsnClientMapping {
+	maxClients := defaultMaxDsnClients
+	if maxClientsRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_CUSTOM_DSNS_MAX_CLIENTS")); maxClientsRaw != "" {
+		if value, err := strconv.Atoi(maxClientsRaw); err == nil && value >= 0 {
+			maxClients = value
+		} else {
+			globalLogger.Warn().Msgf("Invalid value of SENTRY_K8S_CUSTOM_DSNS_MAX_CLIENTS: %q", maxClientsRaw)
+		}
+	}
+
+	idleTimeout := defaultDsnClientIdleTime
+	if idleTimeoutRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_CUSTOM_DSNS_IDLE_TIMEOUT")); idleTimeoutRaw != "" {
+		if value, err := time.ParseDuration(idleTimeoutRaw); err == nil && value >= 0 {
+			idleTimeout = value
+		} else {
+			globalLogger.Warn().Msgf("Invalid value of SENTRY_K8S_CUSTOM_DSNS_IDLE_TIMEOUT: %q", idleTimeoutRaw)
+		}
+	}
+
 	return &DsnClientMapping{
 		mutex:         sync.RWMutex{},
 		clientMap:     make(map[string]*sentry.Client),
+		lastUsed:      make(map[string]time.Time),
 		customDsnFlag: isTruthy(os.Getenv("SENTRY_K8S_CUSTOM_DSNS")),
+		maxClients:    maxClients,
+		idleTimeout:   idleTimeout,
+	}
+}
+
+// Applies the configured per-DSN overrides to the client options
+func applyDsnClientOverrides(options *sentry.ClientOptions) {
+	for _, override := range agentConfig.DsnOverrides {
+		if override.Dsn != options.Dsn {
+			continue
+		}
+		if override.Environment != "" {
+			options.Environment = override.Environment
+		}
+		if override.SampleRate != nil {
+			options.SampleRate = *override.SampleRate
+		}
+		if override.Debug != nil {
+			options.Debug = *override.Debug
+		}
+		return
 	}
 }
 
 // Return client if added successfully
 // (also returns client if already exists)
+//
+// The new client inherits all the passed options (usually the options
+// of the main client, with the DSN replaced).
 func (d *DsnClientMapping) AddClientToMap(options sentry.ClientOptions) (*sentry.Client, error) {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+	applyDsnClientOverrides(&options)
 
 	// Create a new client for the dsn
 	// even if client already exists, it
 	// will be re-initialized with a new client
-	newClient, err := sentry.NewClient(
-		sentry.ClientOptions{
-			Dsn:              options.Dsn,
-			Debug:            true,
-			AttachStacktrace: true,
-		},
-	)
+	newClient, err := sentry.NewClient(options)
 	if err != nil {
 		return nil, err
 	}
+
+	d.mutex.Lock()
+	oldClient, replaced := d.clientMap[options.Dsn]
 	d.clientMap[options.Dsn] = newClient
+	d.lastUsed[options.Dsn] = time.Now()
+	evicted := d.evictOverLimit()
+	d.mutex.Unlock()
+
+	if replaced {
+		evicted = append(evicted, oldClient)
+	}
+	flushClientsInBackground(evicted)
 	return newClient, nil
 }
 
-// Retrieve a client with given dsn
-func (d *DsnClientMapping) GetClientFromMap(dsn string) (*sentry.Client, bool) {
+// Removes the least recently used clients while the pool is over the limit.
+// Must be called with the mutex locked.
+func (d *DsnClientMapping) evictOverLimit() []*sentry.Client {
+	evicted := []*sentry.Client{}
+	for d.maxClients > 0 && len(d.clientMap) > d.maxClients {
+		var oldestDsn string
+		var oldestTime time.Time
+		for dsn, lastUsed := range d.lastUsed {
+			if oldestDsn == "" || lastUsed.Before(oldestTime) {
+				oldestDsn, oldestTime = dsn, lastUsed
+			}
+		}
+		evicted = append(evicted, d.clientMap[oldestDsn])
+		delete(d.clientMap, oldestDsn)
+		delete(d.lastUsed, oldestDsn)
+	}
+	return evicted
+}
+
+// Removes the clients that have not been used since the idle timeout,
+// flushing the events they still buffer. Returns the number of evicted clients.
+func (d *DsnClientMapping) EvictIdleClients(now time.Time) int {
+	if d.idleTimeout <= 0 {
+		return 0
+	}
+
+	d.mutex.Lock()
+	evicted := []*sentry.Client{}
+	for dsn, lastUsed := range d.lastUsed {
+		if now.Sub(lastUsed) >= d.idleTimeout {
+			evicted = append(evicted, d.clientMap[dsn])
+			delete(d.clientMap, dsn)
+			delete(d.lastUsed, dsn)
+		}
+	}
+	d.mutex.Unlock()
+
+	flushClientsInBackground(evicted)
+	return len(evicted)
+}
+
+// Periodically evicts idle clients, until the context is cancelled
+func (d *DsnClientMapping) runIdleClientEviction(ctx context.Context) {
+	defer reportPanicAndCrash()
+
+	if d.idleTimeout <= 0 {
+		return
+	}
+	interval := d.idleTimeout / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if evicted := d.EvictIdleClients(now); evicted > 0 {
+				globalLogger.Debug().Msgf("Evicted %d idle client(s) from the DSN client pool", evicted)
+			}
+		}
+	}
+}
+
+// Returns the number of clients in the pool
+func (d *DsnClientMapping) Size() int {
 	d.mutex.RLock()
 	defer d.mutex.RUnlock()
+	return len(d.clientMap)
+}
+
+// Flushes all the clients in the pool, waiting at most for the timeout.
+// Returns false if some of the clients could not be flushed in time.
+func (d *DsnClientMapping) FlushAll(timeout time.Duration) bool {
+	d.mutex.RLock()
+	clients := make([]*sentry.Client, 0, len(d.clientMap))
+	for _, client := range d.clientMap {
+		clients = append(clients, client)
+	}
+	d.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	allFlushed := true
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *sentry.Client) {
+			defer wg.Done()
+			if !client.Flush(timeout) {
+				mutex.Lock()
+				allFlushed = false
+				mutex.Unlock()
+			}
+		}(client)
+	}
+	wg.Wait()
+	return allFlushed
+}
+
+func flushClientsInBackground(clients []*sentry.Client) {
+	for _, client := range clients {
+		go client.Flush(dsnClientFlushTimeout)
+	}
+}
+
+// Remove the client for the given dsn (e.g. when the DSN was rotated),
+// flushing the events it still buffers
+func (d *DsnClientMapping) RemoveClient(dsn string) {
+	d.mutex.Lock()
+	client, ok := d.clientMap[dsn]
+	delete(d.clientMap, dsn)
+	delete(d.lastUsed, dsn)
+	d.mutex.Unlock()
+
+	if ok {
+		flushClientsInBackground([]*sentry.Client{client})
+	}
+}
+
+// Retrieve a client with given dsn
+func (d *DsnClientMapping) GetClientFromMap(dsn string) (*sentry.Client, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 
 	// Check if we have this dsn
 	existingClient, ok := d.clientMap[dsn]
+	if ok {
+		d.lastUsed[dsn] = time.Now()
+	}
 	return existingClient, ok
 }
 
 func (d *DsnClientMapping) GetClientFromObject(ctx context.Context, object metav1.Object, clientOptions sentry.ClientOptions) (*sentry.Client, bool) {
+	clients := d.GetClientsFromObject(ctx, object, clientOptions)
+	if len(clients) == 0 {
+		return nil, false
+	}
+	return clients[0], true
+}
+
+// Returns the clients for all the DSNs in the object's annotations
+// (the annotation may contain a comma-separated list of DSNs)
+func (d *DsnClientMapping) GetClientsFromObject(ctx context.Context, object metav1.Object, clientOptions sentry.ClientOptions) []*sentry.Client {
 	// If the custom DSN flag is set to false
 	// then avoid searching for the custom DSN
 	// or adding an alternative client and instead
-	// just return nil as the client
+	// just return no clients
 	if !d.customDsnFlag {
-		return nil, false
+		return nil
 	}
 
 	// Find DSN annotation from the object
-	altDsn, err := searchDsn(ctx, object)
+	altDsns, err := searchDsn(ctx, object)
 	if err != nil {
-		return nil, false
+		return nil
 	}
 
-	// If we did find an alternative DSN
-	if altDsn != "" {
-		// Attempt to retrieve the corresponding client
-		client, _ := dsnClientMapping.GetClientFromMap(altDsn)
-		if client == nil {
-			// create new client
-			clientOptions.Dsn = altDsn
-			client, err = dsnClientMapping.AddClientToMap(clientOptions)
-			if err != nil {
-				return nil, false
-			}
+	clients := []*sentry.Client{}
+	for _, altDsn := range splitDsnList(altDsns) {
+		client, err := d.GetOrCreateClient(altDsn, clientOptions)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Msgf("Cannot create a client for the DSN annotation: %v", err)
+			continue
 		}
-		return client, true
+		clients = append(clients, client)
 	}
-	return nil, false
+	return clients
 }
 
-// Recursive function to find if there is a DSN annotation
+// Retrieve the client for the given DSN, creating it if needed
+func (d *DsnClientMapping) GetOrCreateClient(dsn string, clientOptions sentry.ClientOptions) (*sentry.Client, error) {
+	// Attempt to retrieve the corresponding client
+	client, _ := d.GetClientFromMap(dsn)
+	if client != nil {
+		return client, nil
+	}
+	// create new client
+	clientOptions.Dsn = dsn
+	return d.AddClientToMap(clientOptions)
+}
+
+// Find if there is a DSN annotation on the object or its owners,
+// falling back to the annotation on the object's namespace
 func searchDsn(ctx context.Context, obj metav1.Object) (string, error) {
-	dsn, ok := obj.GetAnnotations()[DSNAnnotation]
-	if ok {
-		return dsn, nil
+	namespace := obj.GetNamespace()
+
+	// The object's own annotations take precedence
+	dsn, found, err := getDsnFromAnnotations(obj.GetAnnotations(), namespace)
+	if found {
+		return dsn, err
 	}
 
-	if len(obj.GetOwnerReferences()) == 0 {
-		return "", nil
+	ownerAnnotations, complete := findOwnerDsnAnnotations(ctx, obj)
+	if ownerAnnotations != nil {
+		dsn, _, err = getDsnFromAnnotations(ownerAnnotations, namespace)
+		return dsn, err
+	}
+	if !complete {
+		zerolog.Ctx(ctx).Debug().Msgf(
+			"An owner of %s/%s cannot be found, using the %q DSN fallback policy",
+			namespace, obj.GetName(), getDsnFallbackPolicy(),
+		)
+		if getDsnFallbackPolicy() == DsnFallbackDefault {
+			return "", nil
+		}
 	}
 
-	owningRef := obj.GetOwnerReferences()[0]
-	owningObject, ok := findObject(ctx, owningRef.Kind, obj.GetNamespace(), owningRef.Name)
+	if namespaceObj, ok := findClusterObjectCached(ctx, KindNamespace, namespace); ok {
+		dsn, _, err = getDsnFromAnnotations(namespaceObj.GetAnnotations(), namespace)
+		return dsn, err
+	}
+	return "", nil
+}
 
-	if !ok {
-		return "", errors.New("the DSN cannot be found")
+// Reads the DSN either directly from the DSN annotation,
+// or from the secret referenced by the DSN secret annotation
+func getDsnFromAnnotations(annotations map[string]string, namespace string) (dsn string, found bool, err error) {
+	if secretRefs, ok := annotations[DSNSecretAnnotation]; ok {
+		// Several secrets can be referenced, separated with commas
+		dsns := []string{}
+		for _, secretRef := range strings.Split(secretRefs, ",") {
+			dsn, err = resolveDsnSecret(namespace, secretRef)
+			if err != nil {
+				return "", true, err
+			}
+			dsns = append(dsns, dsn)
+		}
+		return strings.Join(dsns, ","), true, nil
+	}
+	if dsn, ok := annotations[DSNAnnotation]; ok {
+		return dsn, true, nil
 	}
+	return "", false, nil
+}
 
-	return searchDsn(ctx, owningObject)
+// Resolves a "<secret name>/<key>" reference to the DSN stored in the secret
+func resolveDsnSecret(namespace string, secretRef string) (string, error) {
+	secretName, key, found := strings.Cut(strings.TrimSpace(secretRef), "/")
+	if !found || key == "" {
+		key = defaultDsnSecretKey
+	}
+	if secretName == "" {
+		return "", fmt.Errorf("invalid DSN secret reference: %q", secretRef)
+	}
+
+	secret, ok := getDsnSecret(namespace, secretName)
+	if !ok {
+		return "", fmt.Errorf("the DSN secret %s/%s cannot be found", namespace, secretName)
+	}
+	value, ok := secret.Data[key]
+	if !ok {
+		return "", fmt.Errorf("the DSN secret %s/%s has no key %q", namespace, secretName, key)
+	}
+	return strings.TrimSpace(string(value)), nil
 }
 
 func findObject(ctx context.Context, kind string, namespace string, name string) (metav1.Object, bool) {
@@ -124,6 +396,7 @@ func findObject(ctx context.Context, kind string, namespace string, name string)
 
 	switch kind {
 	case KindPod:
+		countObjectLookup(kind, ObjectLookupAPI)
 		pod, err := clientset.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
 		if err != nil {
 			return nil, false
@@ -136,10 +409,12 @@ func findObject(ctx context.Context, kind string, namespace string, name string)
 			obj, ok, err := replicasetInformer.GetIndexer().GetByKey(namespace + "/" + name)
 			if ok && err == nil {
 				replicaSet = obj.(*v1.ReplicaSet)
+				countObjectLookup(kind, ObjectLookupInformer)
 			}
 		}
 		if replicaSet == nil {
 			// Query replicaset with kubernetes API
+			countObjectLookup(kind, ObjectLookupAPI)
 			replicaSet, err = clientset.AppsV1().ReplicaSets(namespace).Get(context.Background(), name, metav1.GetOptions{})
 			if err != nil {
 				return nil, false
@@ -153,10 +428,12 @@ func findObject(ctx context.Context, kind string, namespace string, name string)
 			obj, ok, err := deploymentInformer.GetIndexer().GetByKey(namespace + "/" + name)
 			if ok && err == nil {
 				deployment = obj.(*v1.Deployment)
+				countObjectLookup(kind, ObjectLookupInformer)
 			}
 		}
 		if deployment == nil {
 			// Query deployment with kubernetes API
+			countObjectLookup(kind, ObjectLookupAPI)
 			deployment, err = clientset.AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
 			if err != nil {
 				return nil, false
@@ -170,10 +447,12 @@ func findObject(ctx context.Context, kind string, namespace string, name string)
 			obj, ok, err := jobInformer.GetIndexer().GetByKey(namespace + "/" + name)
 			if ok && err == nil {
 				job = obj.(*batchv1.Job)
+				countObjectLookup(kind, ObjectLookupInformer)
 			}
 		}
 		if job == nil {
 			// Query job with kubernetes API
+			countObjectLookup(kind, ObjectLookupAPI)
 			job, err = clientset.BatchV1().Jobs(namespace).Get(context.Background(), name, metav1.GetOptions{})
 			if err != nil {
 				return nil, false
@@ -187,16 +466,33 @@ func findObject(ctx context.Context, kind string, namespace string, name string)
 			obj, ok, err := cronjobInformer.GetIndexer().GetByKey(namespace + "/" + name)
 			if ok && err == nil {
 				cronjob = obj.(*batchv1.CronJob)
+				countObjectLookup(kind, ObjectLookupInformer)
 			}
 		}
 		if cronjob == nil {
 			// Query cronjob with kubernetes API
+			countObjectLookup(kind, ObjectLookupAPI)
 			cronjob, err = clientset.BatchV1().CronJobs(namespace).Get(context.Background(), name, metav1.GetOptions{})
 			if err != nil {
 				return nil, false
 			}
 		}
 		return cronjob, true
+	case KindNamespace:
+		// Namespaces and nodes are cluster-scoped, so the namespace argument is ignored
+		countObjectLookup(kind, ObjectLookupAPI)
+		namespaceObj, err := clientset.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, false
+		}
+		return namespaceObj, true
+	case KindNode:
+		countObjectLookup(kind, ObjectLookupAPI)
+		node, err := clientset.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, false
+		}
+		return node, true
 	default:
 		return nil, false
 	}
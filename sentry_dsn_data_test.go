@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/getsentry/sentry-go"
 	v1 "k8s.io/api/apps/v1"
@@ -306,3 +307,91 @@ func TestFindObject(t *testing.T) {
 		t.Errorf("The returned replicaset is not equal to the original replicaset")
 	}
 }
+
+func TestAddClientToMapInheritsOptions(t *testing.T) {
+	fakeDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567890"
+	overriddenDsn := "https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1234567891"
+
+	config, err := parseAgentConfig([]byte(`
+dsnOverrides:
+  - dsn: "` + overriddenDsn + `"
+    environment: staging
+    sampleRate: 0.5
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse the agent config: %v", err)
+	}
+	oldConfig := agentConfig
+	defer func() { agentConfig = oldConfig }()
+	agentConfig = config
+
+	clientOptions := sentry.ClientOptions{
+		Dsn:         fakeDsn,
+		Environment: "production",
+		SampleRate:  1.0,
+		BeforeSend: func(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+			return event
+		},
+	}
+	clientMapping := NewDsnClientMapping()
+
+	client, err := clientMapping.AddClientToMap(clientOptions)
+	if err != nil {
+		t.Fatalf("Failed to add client to map: %v", err)
+	}
+	if client.Options().Environment != "production" || client.Options().BeforeSend == nil || client.Options().Debug {
+		t.Errorf("The client did not inherit the passed options: %+v", client.Options())
+	}
+
+	clientOptions.Dsn = overriddenDsn
+	client, err = clientMapping.AddClientToMap(clientOptions)
+	if err != nil {
+		t.Fatalf("Failed to add client to map: %v", err)
+	}
+	if client.Options().Environment != "staging" || client.Options().SampleRate != 0.5 {
+		t.Errorf("The DSN overrides were not applied: %+v", client.Options())
+	}
+}
+
+func TestDsnClientMappingEviction(t *testing.T) {
+	t.Setenv("SENTRY_K8S_CUSTOM_DSNS_MAX_CLIENTS", "2")
+	t.Setenv("SENTRY_K8S_CUSTOM_DSNS_IDLE_TIMEOUT", "10m")
+	clientMapping := NewDsnClientMapping()
+
+	dsns := []string{
+		"https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/1",
+		"https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/2",
+		"https://c6f9a148ee0775891414b50b9af35959@o4506191942320128.ingest.sentry.io/3",
+	}
+	for _, dsn := range dsns[:2] {
+		if _, err := clientMapping.AddClientToMap(sentry.ClientOptions{Dsn: dsn}); err != nil {
+			t.Fatalf("Failed to add client to map: %v", err)
+		}
+	}
+	// Make the first client the most recently used one
+	clientMapping.lastUsed[dsns[1]] = time.Now().Add(-time.Minute)
+	clientMapping.GetClientFromMap(dsns[0])
+
+	if _, err := clientMapping.AddClientToMap(sentry.ClientOptions{Dsn: dsns[2]}); err != nil {
+		t.Fatalf("Failed to add client to map: %v", err)
+	}
+	if clientMapping.Size() != 2 {
+		t.Errorf("Pool size expected: %d, actual: %d", 2, clientMapping.Size())
+	}
+	if _, ok := clientMapping.GetClientFromMap(dsns[1]); ok {
+		t.Errorf("The least recently used client should have been evicted")
+	}
+
+	// Only the clients idle for longer than the timeout are evicted
+	clientMapping.lastUsed[dsns[0]] = time.Now().Add(-time.Hour)
+	if evicted := clientMapping.EvictIdleClients(time.Now()); evicted != 1 {
+		t.Errorf("Evicted clients expected: %d, actual: %d", 1, evicted)
+	}
+	if _, ok := clientMapping.GetClientFromMap(dsns[2]); !ok {
+		t.Errorf("The recently used client should not have been evicted")
+	}
+
+	if !clientMapping.FlushAll(time.Second) {
+		t.Errorf("Failed to flush the clients")
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// What the work is sharded by
+const (
+	ShardByNamespace = "namespace"
+	ShardByUID       = "uid"
+)
+
+const defaultShardGroup = "sentry-kubernetes"
+
+// Every replica keeps its own Lease with this label, the set of
+// non-expired Leases in the group is the set of replicas
+var ShardGroupLabel = "k8s.sentry.io/shard-group"
+
+type shardingConfig struct {
+	namespace     string
+	group         string
+	identity      string
+	shardBy       string
+	leaseDuration time.Duration
+}
+
+func isShardingEnabled() bool {
+	return isTruthy(os.Getenv("SENTRY_K8S_SHARDING"))
+}
+
+func getShardingConfig() (*shardingConfig, error) {
+	config := &shardingConfig{
+		namespace: getLeaseNamespace("SENTRY_K8S_SHARDING_NAMESPACE"),
+		group:     strings.TrimSpace(os.Getenv("SENTRY_K8S_SHARD_GROUP")),
+		shardBy:   strings.ToLower(strings.TrimSpace(os.Getenv("SENTRY_K8S_SHARD_BY"))),
+	}
+	if config.group == "" {
+		config.group = defaultShardGroup
+	}
+	switch config.shardBy {
+	case "":
+		config.shardBy = ShardByNamespace
+	case ShardByNamespace, ShardByUID:
+	default:
+		return nil, fmt.Errorf("invalid value of SENTRY_K8S_SHARD_BY: %q", config.shardBy)
+	}
+
+	var err error
+	if config.identity, err = getReplicaIdentity(); err != nil {
+		return nil, err
+	}
+	if config.leaseDuration, err = getLeaseDuration("SENTRY_K8S_SHARDING_LEASE_DURATION"); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// The current replicas of the shard group, as seen by this replica
+type shardMembership struct {
+	mutex    sync.RWMutex
+	enabled  bool
+	shardBy  string
+	identity string
+	members  []string
+}
+
+var shards = &shardMembership{}
+
+// Returns the member that owns the key, using rendezvous (highest random
+// weight) hashing: when a member joins or leaves, only the keys owned by
+// that member move
+func rendezvousOwner(members []string, key string) string {
+	var owner string
+	var ownerWeight uint64
+	for _, member := range members {
+		hash := fnv.New64a()
+		hash.Write([]byte(member))
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		weight := mixHash(hash.Sum64())
+		if owner == "" || weight > ownerWeight || (weight == ownerWeight && member < owner) {
+			owner, ownerWeight = member, weight
+		}
+	}
+	return owner
+}
+
+// Spreads the bits of a hash (the MurmurHash3 finalizer), because FNV hashes
+// of similar strings are too close to each other to be used as weights
+func mixHash(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Returns the shard key of an object: its namespace or its UID
+func (s *shardMembership) shardKey(namespace string, uid types.UID) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.shardBy == ShardByUID && uid != "" {
+		return string(uid)
+	}
+	return namespace
+}
+
+// Reports whether this replica is responsible for the key.
+// Without sharding every key is owned.
+func (s *shardMembership) owns(key string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if !s.enabled {
+		return true
+	}
+	return rendezvousOwner(s.members, key) == s.identity
+}
+
+func (s *shardMembership) ownsObject(namespace string, uid types.UID) bool {
+	return s.owns(s.shardKey(namespace, uid))
+}
+
+// Sets the members, returns true if they changed
+func (s *shardMembership) setMembers(members []string) bool {
+	sort.Strings(members)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if strings.Join(members, ",") == strings.Join(s.members, ",") {
+		return false
+	}
+	s.members = members
+	return true
+}
+
+func (s *shardMembership) getMembers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return append([]string(nil), s.members...)
+}
+
+func shardLeaseName(config *shardingConfig) string {
+	return config.group + "-" + config.identity
+}
+
+// Creates or renews the Lease of this replica
+func renewShardLease(ctx context.Context, clientset ClientsetInterface, config *shardingConfig, now time.Time) error {
+	leases := clientset.CoordinationV1().Leases(config.namespace)
+	leaseDurationSeconds := int32(config.leaseDuration.Seconds())
+	renewTime := metav1.NewMicroTime(now)
+
+	lease, err := leases.Get(ctx, shardLeaseName(config), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardLeaseName(config),
+				Namespace: config.namespace,
+				Labels:    map[string]string{ShardGroupLabel: config.group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &config.identity,
+				LeaseDurationSeconds: &leaseDurationSeconds,
+				AcquireTime:          &renewTime,
+				RenewTime:            &renewTime,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &config.identity
+	lease.Spec.LeaseDurationSeconds = &leaseDurationSeconds
+	lease.Spec.RenewTime = &renewTime
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// Lists the replicas whose Leases have not expired
+func listShardMembers(ctx context.Context, clientset ClientsetInterface, config *shardingConfig, now time.Time) ([]string, error) {
+	leaseList, err := clientset.CoordinationV1().Leases(config.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: ShardGroupLabel + "=" + config.group,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	members := []string{}
+	for _, lease := range leaseList.Items {
+		spec := lease.Spec
+		if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiresAt := spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second)
+		if expiresAt.After(now) {
+			members = append(members, *spec.HolderIdentity)
+		}
+	}
+	return members, nil
+}
+
+// Renews the Lease of this replica and refreshes the members of the group,
+// returns true if the members changed
+func refreshShardMembers(ctx context.Context, clientset ClientsetInterface, config *shardingConfig) bool {
+	logger := zerolog.Ctx(ctx)
+
+	now := time.Now()
+	if err := renewShardLease(ctx, clientset, config, now); err != nil {
+		logger.Error().Msgf("Cannot renew the shard lease: %v", err)
+		return false
+	}
+	members, err := listShardMembers(ctx, clientset, config, now)
+	if err != nil {
+		logger.Error().Msgf("Cannot list the shard members: %v", err)
+		return false
+	}
+	// This replica is a member even if the list is stale
+	found := false
+	for _, member := range members {
+		found = found || member == config.identity
+	}
+	if !found {
+		members = append(members, config.identity)
+	}
+	if !shards.setMembers(members) {
+		return false
+	}
+	logger.Info().Msgf("Shard members changed: %s", strings.Join(shards.getMembers(), ", "))
+	return true
+}
+
+// Periodically refreshes the members of the group, calling onChange when
+// they change. When the context is cancelled, the Lease of this replica is
+// deleted, so that the other replicas take over immediately.
+func runShardMembership(ctx context.Context, clientset ClientsetInterface, config *shardingConfig, onChange func()) {
+	logger := zerolog.Ctx(ctx)
+
+	ticker := time.NewTicker(config.leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			deleteCtx, cancel := context.WithTimeout(detachContext(ctx), 2*time.Second)
+			err := clientset.CoordinationV1().Leases(config.namespace).Delete(deleteCtx, shardLeaseName(config), metav1.DeleteOptions{})
+			cancel()
+			if err != nil && !errors.IsNotFound(err) {
+				logger.Warn().Msgf("Cannot delete the shard lease: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if refreshShardMembers(ctx, clientset, config) {
+				onChange()
+			}
+		}
+	}
+}
+
+// Runs the watchers on this replica's share of the work. When sharding by
+// namespace and the namespaces are listed explicitly, only the owned
+// namespaces are watched, and the watchers are started and stopped as the
+// namespaces move between replicas. Otherwise all replicas watch everything,
+// and each one only processes the objects it owns.
+func runWithSharding(ctx context.Context, clientset ClientsetInterface, config *shardingConfig, namespaces []string, startWatchers func(ctx context.Context, namespaces []string, wg *sync.WaitGroup)) {
+	logger := zerolog.Ctx(ctx)
+
+	shards.mutex.Lock()
+	shards.enabled = true
+	shards.shardBy = config.shardBy
+	shards.identity = config.identity
+	shards.members = []string{config.identity}
+	shards.mutex.Unlock()
+
+	// The members are known before any work is started
+	refreshShardMembers(ctx, clientset, config)
+	changes := make(chan struct{}, 1)
+	membershipDone := make(chan struct{})
+	go func() {
+		defer reportPanicAndCrash()
+		defer close(membershipDone)
+		runShardMembership(ctx, clientset, config, func() {
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	watchAll := config.shardBy == ShardByUID || (len(namespaces) == 1 && namespaces[0] == v1.NamespaceAll)
+	if watchAll {
+		startWatchers(ctx, namespaces, &wg)
+		<-ctx.Done()
+	} else {
+		watched := make(map[string]context.CancelFunc)
+		rebalance := func() {
+			for _, namespace := range namespaces {
+				cancel, isWatched := watched[namespace]
+				owned := shards.owns(namespace)
+				if owned && !isWatched {
+					logger.Info().Msgf("Namespace %q was assigned to this replica", namespace)
+					namespaceCtx, cancel := context.WithCancel(ctx)
+					watched[namespace] = cancel
+					startWatchers(namespaceCtx, []string{namespace}, &wg)
+				} else if !owned && isWatched {
+					logger.Info().Msgf("Namespace %q was moved to another replica", namespace)
+					cancel()
+					delete(watched, namespace)
+				}
+			}
+		}
+
+		rebalance()
+		for ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case <-changes:
+				rebalance()
+			}
+		}
+		for _, cancel := range watched {
+			cancel()
+		}
+	}
+
+	shutdown(&wg)
+	<-membershipDone
+}
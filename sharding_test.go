@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRendezvousOwner(t *testing.T) {
+	members := []string{"replica-a", "replica-b", "replica-c"}
+	owners := map[string]string{}
+	counts := map[string]int{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("namespace-%d", i)
+		owners[key] = rendezvousOwner(members, key)
+		counts[owners[key]]++
+	}
+	for _, member := range members {
+		if counts[member] < 800 {
+			t.Errorf("The keys are not spread evenly: %v", counts)
+		}
+	}
+
+	// When a member leaves, only its keys move
+	for key, owner := range owners {
+		newOwner := rendezvousOwner(members[:2], key)
+		if owner != "replica-c" && newOwner != owner {
+			t.Errorf("Key %s moved from %s to %s", key, owner, newOwner)
+		}
+	}
+}
+
+func TestShardMembership(t *testing.T) {
+	defer func() { shards = &shardMembership{} }()
+
+	fakeClientset := fake.NewSimpleClientset()
+	ctx := context.Background()
+	newConfig := func(identity string) *shardingConfig {
+		return &shardingConfig{
+			namespace:     "TestShardMembershipNamespace",
+			group:         "TestShardMembershipGroup",
+			identity:      identity,
+			shardBy:       ShardByNamespace,
+			leaseDuration: 15 * time.Second,
+		}
+	}
+	configA := newConfig("replica-a")
+	configB := newConfig("replica-b")
+	configC := newConfig("replica-c")
+
+	now := time.Now()
+	for _, config := range []*shardingConfig{configA, configB} {
+		if err := renewShardLease(ctx, fakeClientset, config, now); err != nil {
+			t.Fatalf("Cannot renew the lease: %v", err)
+		}
+	}
+	// The lease of replica-c has expired
+	if err := renewShardLease(ctx, fakeClientset, configC, now.Add(-time.Minute)); err != nil {
+		t.Fatalf("Cannot renew the lease: %v", err)
+	}
+
+	shards = &shardMembership{enabled: true, shardBy: ShardByNamespace, identity: "replica-a"}
+	if !refreshShardMembers(ctx, fakeClientset, configA) {
+		t.Errorf("The members should have changed")
+	}
+	members := shards.getMembers()
+	if len(members) != 2 || members[0] != "replica-a" || members[1] != "replica-b" {
+		t.Errorf("Unexpected members: %v", members)
+	}
+	if refreshShardMembers(ctx, fakeClientset, configA) {
+		t.Errorf("The members should not have changed")
+	}
+
+	for i := 0; i < 10; i++ {
+		namespace := fmt.Sprintf("namespace-%d", i)
+		expected := rendezvousOwner(members, namespace) == "replica-a"
+		if shards.ownsObject(namespace, "") != expected {
+			t.Errorf("Unexpected ownership of %s", namespace)
+		}
+	}
+}
+
+func TestRunWithSharding(t *testing.T) {
+	defer func() { shards = &shardMembership{} }()
+	t.Setenv("SENTRY_K8S_SHUTDOWN_TIMEOUT", "100ms")
+
+	fakeClientset := fake.NewSimpleClientset()
+	config := &shardingConfig{
+		namespace:     "TestRunWithShardingNamespace",
+		group:         "TestRunWithShardingGroup",
+		identity:      "replica-a",
+		shardBy:       ShardByNamespace,
+		leaseDuration: 15 * time.Second,
+	}
+
+	var mutex sync.Mutex
+	started := []string{}
+	startWatchers := func(ctx context.Context, namespaces []string, wg *sync.WaitGroup) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		started = append(started, namespaces...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runWithSharding(ctx, fakeClientset, config, []string{"ns-1", "ns-2", "ns-3"}, startWatchers)
+	}()
+
+	// The only replica owns all the namespaces
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		startedCount := len(started)
+		mutex.Unlock()
+		if startedCount == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Watchers started for %d namespaces, expected: %d", startedCount, 3)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+
+	// The lease is deleted on shutdown, so that other replicas take over
+	_, err := fakeClientset.CoordinationV1().Leases(config.namespace).Get(context.TODO(), shardLeaseName(config), metav1.GetOptions{})
+	if err == nil {
+		t.Errorf("The shard lease should have been deleted")
+	}
+}
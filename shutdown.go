@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	globalLogger "github.com/rs/zerolog/log"
+	"k8s.io/client-go/tools/cache"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// The maximum time to wait for the events that are being processed
+// and for the Sentry clients to send the buffered events, altogether
+func getShutdownTimeout() time.Duration {
+	timeoutRaw := strings.TrimSpace(os.Getenv("SENTRY_K8S_SHUTDOWN_TIMEOUT"))
+	if timeoutRaw == "" {
+		return defaultShutdownTimeout
+	}
+	timeout, err := time.ParseDuration(timeoutRaw)
+	if err != nil || timeout < 0 {
+		globalLogger.Warn().Msgf("Invalid value of SENTRY_K8S_SHUTDOWN_TIMEOUT: %q", timeoutRaw)
+		return defaultShutdownTimeout
+	}
+	return timeout
+}
+
+// Waits for the wait group, returns false if the timeout expired first
+func waitWithTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Tracks the calls of the informer event handlers that are running. The
+// informers stop when the context is cancelled, but a handler may still be
+// sending a check-in, and an informer may still deliver events after it was
+// stopped; client-go v0.25 cannot wait for them, so they are tracked here.
+type handlerTracker struct {
+	mutex sync.Mutex
+	idle  *sync.Cond
+	// The number of calls that are running
+	running int
+	// Set when the shutdown starts, the calls are dropped from then on
+	stopping bool
+}
+
+func newHandlerTracker() *handlerTracker {
+	tracker := &handlerTracker{}
+	tracker.idle = sync.NewCond(&tracker.mutex)
+	return tracker
+}
+
+var informerHandlers = newHandlerTracker()
+
+// Accepts calls again, when the informers are started after a shutdown
+// (e.g. when the replica becomes the leader again)
+func (t *handlerTracker) start() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stopping = false
+}
+
+// Returns false if the shutdown started, the call must be dropped then
+func (t *handlerTracker) enter() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopping {
+		return false
+	}
+	t.running++
+	return true
+}
+
+func (t *handlerTracker) exit() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.running--
+	if t.running == 0 {
+		t.idle.Broadcast()
+	}
+}
+
+// Drops the calls from now on, and waits for the calls that are running.
+// Returns false if the timeout expired first.
+func (t *handlerTracker) stopAndWait(timeout time.Duration) bool {
+	t.mutex.Lock()
+	t.stopping = true
+	t.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.mutex.Lock()
+		for t.running > 0 {
+			t.idle.Wait()
+		}
+		t.mutex.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Runs the call of a handler unless the shutdown started
+func (t *handlerTracker) run(call func()) {
+	if !t.enter() {
+		return
+	}
+	defer t.exit()
+	call()
+}
+
+// Wraps the event handler of an informer, so that the shutdown waits
+// for its calls that are running before the clients are flushed
+func trackEventHandler(handler cache.ResourceEventHandler) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			informerHandlers.run(func() { handler.OnAdd(obj) })
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			informerHandlers.run(func() { handler.OnUpdate(oldObj, newObj) })
+		},
+		DeleteFunc: func(obj interface{}) {
+			informerHandlers.run(func() { handler.OnDelete(obj) })
+		},
+	}
+}
+
+// Flushes the main client, all the clients for custom DSNs,
+// and the self-monitoring client
+func flushAllClients(timeout time.Duration) bool {
+	var wg sync.WaitGroup
+	var mainFlushed, poolFlushed bool
+	selfFlushed := true
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		mainFlushed = sentry.Flush(timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		poolFlushed = dsnClientMapping.FlushAll(timeout)
+	}()
+	if selfMonitoringHub != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			selfFlushed = selfMonitoringHub.Flush(timeout)
+		}()
+	}
+	wg.Wait()
+	return mainFlushed && poolFlushed && selfFlushed
+}
+
+// Stops the watchers and informers (they stop when the context is cancelled),
+// waits for the events that are being processed and for the informer
+// handlers that are running, and flushes all clients. The timeout is
+// shared by all the steps, so that the agent stops within the grace period.
+func shutdown(wg *sync.WaitGroup) {
+	timeout := getShutdownTimeout()
+	deadline := time.Now().Add(timeout)
+	globalLogger.Info().Msgf("Stopping the watchers and flushing events (timeout: %s)", timeout)
+
+	if !waitWithTimeout(wg, time.Until(deadline)) {
+		globalLogger.Warn().Msg("Timed out while waiting for the watchers to stop")
+	}
+	if !informerHandlers.stopAndWait(time.Until(deadline)) {
+		globalLogger.Warn().Msg("Timed out while waiting for the informer handlers")
+	}
+	if !flushAllClients(time.Until(deadline)) {
+		globalLogger.Warn().Msg("Timed out while flushing the Sentry clients, some events may be lost")
+	}
+	globalLogger.Info().Msg("Watchers stopped and events flushed")
+}
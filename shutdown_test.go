@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDetachContext(t *testing.T) {
+	fakeClientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(setClientsetOnContext(context.Background(), fakeClientset))
+	cancel()
+
+	detachedCtx := detachContext(ctx)
+	if detachedCtx.Err() != nil {
+		t.Errorf("The detached context should not be cancelled")
+	}
+	if _, err := getClientsetFromContext(detachedCtx); err != nil {
+		t.Errorf("The detached context should keep the values of its parent: %v", err)
+	}
+}
+
+func TestStartInformersStopsOnCancel(t *testing.T) {
+	defer func() {
+		jobInformer, cronjobInformer, replicasetInformer, deploymentInformer = nil, nil, nil, nil
+	}()
+
+	fakeClientset := fake.NewSimpleClientset()
+	ctx, cancel := context.WithCancel(setClientsetOnContext(context.Background(), fakeClientset))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := startInformers(ctx, "TestStartInformersStopsOnCancel"); err != nil {
+			t.Errorf("Failed to run the informers: %v", err)
+		}
+	}()
+
+	// The informers run until the context is cancelled
+	if waitWithTimeout(&wg, 100*time.Millisecond) {
+		t.Fatalf("The informers stopped before the context was cancelled")
+	}
+	cancel()
+	if !waitWithTimeout(&wg, 5*time.Second) {
+		t.Errorf("The informers did not stop after the context was cancelled")
+	}
+}
+
+func TestGetShutdownTimeout(t *testing.T) {
+	if timeout := getShutdownTimeout(); timeout != defaultShutdownTimeout {
+		t.Errorf("Timeout expected: %s, actual: %s", defaultShutdownTimeout, timeout)
+	}
+	t.Setenv("SENTRY_K8S_SHUTDOWN_TIMEOUT", "30s")
+	if timeout := getShutdownTimeout(); timeout != 30*time.Second {
+		t.Errorf("Timeout expected: %s, actual: %s", 30*time.Second, timeout)
+	}
+}
+
+func TestShutdownWaitsForInformerHandlers(t *testing.T) {
+	defer informerHandlers.start()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := trackEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			close(started)
+			<-release
+		},
+	})
+	go handler.OnAdd(nil)
+	<-started
+
+	var wg sync.WaitGroup
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		shutdown(&wg)
+	}()
+
+	// The clients are flushed only after the handler returned
+	select {
+	case <-shutdownDone:
+		t.Fatalf("The shutdown did not wait for the running handler")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// The events delivered after the shutdown started are dropped
+	dropped := true
+	trackEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { dropped = false },
+	}).OnAdd(nil)
+	if !dropped {
+		t.Errorf("The event delivered during the shutdown should be dropped")
+	}
+
+	close(release)
+	select {
+	case <-shutdownDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The shutdown did not finish after the handler returned")
+	}
+}
+
+func TestShutdownSharesTheTimeout(t *testing.T) {
+	defer informerHandlers.start()
+	t.Setenv("SENTRY_K8S_SHUTDOWN_TIMEOUT", "200ms")
+
+	// Neither the watchers nor the handlers finish
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Done()
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go trackEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			close(started)
+			<-release
+		},
+	}).OnAdd(nil)
+	<-started
+
+	start := time.Now()
+	shutdown(&wg)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The shutdown should stop at the shared deadline, it took %s", elapsed)
+	}
+}
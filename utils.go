@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"path"
 	"strings"
 
 	"github.com/rs/zerolog"
@@ -64,3 +65,16 @@ func getLoggerWithTags(ctx context.Context, tags map[string]string) (context.Con
 	ctx = extendedLogger.WithContext(ctx)
 	return ctx, &extendedLogger
 }
+
+// Returns true if the value matches at least one of the glob patterns
+// (see path.Match for the pattern syntax). The comparison is case-insensitive.
+func matchesAnyPattern(patterns []string, value string) bool {
+	value = strings.ToLower(value)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -86,7 +87,8 @@ func buildSentryEventFromGeneralEvent(ctx context.Context, event *v1.Event, scop
 	// correctly in the enhancers
 	err := runEnhancers(ctx, event, event.InvolvedObject.Kind, involvedObj, scope, sentryEvent)
 	if err != nil {
-		logger.Err(err)
+		countEnhancerError(event.InvolvedObject.Kind)
+		logger.Error().Msgf("Error while running the enhancers: %v", err)
 	}
 	return sentryEvent
 }
@@ -108,36 +110,87 @@ func handleWatchEvent(ctx context.Context, event *watch.Event, cutoffTime metav1
 		return
 	}
 
+	params := &samplingParams{
+		watcher:     eventsWatcherName,
+		namespace:   eventObject.InvolvedObject.Namespace,
+		reason:      eventObject.Reason,
+		eventSource: eventObject.Source.Component,
+		message:     eventObject.Message,
+	}
+	countReceivedEvent(params.watcher, params.namespace)
+	defer observeEventProcessing(params.watcher, time.Now())
+
 	defer addEventToBuffer(eventObject)
 
+	if !shards.ownsObject(eventObject.InvolvedObject.Namespace, eventObject.InvolvedObject.UID) {
+		logger.Trace().Msgf("Skipping an event because the involved object belongs to another shard")
+		countFilteredEvent(params, FilterShard)
+		return
+	}
+
 	namespace := eventObject.Namespace
 	if namespace != "" {
 		ctx, logger = getLoggerWithTag(ctx, "namespace", namespace)
 	}
 
-	// Get event timestamp
-	eventTs := eventObject.LastTimestamp
-	if eventTs.IsZero() {
-		eventTs = metav1.Time(eventObject.EventTime)
-	}
-
+	eventTs := getEventTimestamp(eventObject)
 	if !cutoffTime.IsZero() && !eventTs.IsZero() && eventTs.Before(&cutoffTime) {
 		logger.Debug().Msgf("Ignoring an event because it is too old")
+		countFilteredEvent(params, FilterTooOld)
 		return
 	}
 
 	if eventObject.Type == v1.EventTypeNormal {
 		logger.Debug().Msgf("Skipping an event of type %s", eventObject.Type)
+		countFilteredEvent(params, FilterEventType)
 		return
 	}
 
 	if isFilteredByReason(eventObject) {
 		logger.Debug().Msgf("Skipping an event with reason: %q", eventObject.Reason)
+		countFilteredEvent(params, FilterReason)
 		return
 	}
 
 	if isFilteredByEventSource(eventObject) {
 		logger.Debug().Msgf("Skipping an event with event source: %q", eventObject.Source.Component)
+		countFilteredEvent(params, FilterEventSource)
+		return
+	}
+
+	// Find the object meta that the event is about
+	involvedObject, involvedObjectFound := findObject(ctx, eventObject.InvolvedObject.Kind, eventObject.InvolvedObject.Namespace, eventObject.InvolvedObject.Name)
+
+	var overrides *reportingOverrides
+	if involvedObjectFound {
+		// The owners are fetched once for the annotations, the DSN and the tags
+		ctx = withOwnerChain(ctx, involvedObject)
+		overrides = getReportingOverrides(ctx, involvedObject, eventObject.Reason)
+	} else {
+		overrides = &reportingOverrides{}
+	}
+	if overrides.ignored {
+		logger.Debug().Msgf("Skipping an event because the involved object is ignored via annotations")
+		countFilteredEvent(params, FilterAnnotation)
+		return
+	}
+
+	muteParams := &muteParams{
+		namespace: eventObject.InvolvedObject.Namespace,
+		nodeName:  getEventNodeName(eventObject, involvedObject),
+	}
+	if involvedObjectFound {
+		muteParams.objectLabels = involvedObject.GetLabels()
+	}
+	if suppressIfMuted(ctx, muteParams, eventObject.Message) {
+		countFilteredEvent(params, FilterMute)
+		return
+	}
+
+	sampleRate, keep := sampleEvent(params)
+	if !keep {
+		logger.Debug().Msgf("Skipping an event because of sampling (sample rate: %v)", sampleRate)
+		countFilteredEvent(params, FilterSampling)
 		return
 	}
 
@@ -150,26 +203,53 @@ func handleWatchEvent(ctx context.Context, event *watch.Event, cutoffTime metav1
 	// To avoid concurrency issue
 	hub = hub.Clone()
 	hub.WithScope(func(scope *sentry.Scope) {
-		// Find the object meta that the event is about
-		object, ok := findObject(ctx, eventObject.InvolvedObject.Kind, eventObject.InvolvedObject.Namespace, eventObject.InvolvedObject.Name)
-		if ok {
-			// if DSN annotation provided, we bind a new client with that DSN
-			client, ok := dsnClientMapping.GetClientFromObject(ctx, object, hub.Client().Options())
-			if ok {
-				hub.BindClient(client)
-			}
+		// Routing rules are evaluated first, DSN annotations take precedence over them
+		route := findRoutingRule(ctx, eventObject.InvolvedObject.Namespace)
+		route.applyToScope(scope)
+
+		// If DSN annotations are provided, the event is sent to those DSNs
+		var dsnObject metav1.Object
+		if involvedObjectFound {
+			dsnObject = involvedObject
 		}
+		clients := getDestinationClients(ctx, hub, route, dsnObject)
+		hub.BindClient(clients[0])
 
 		// Pass down clone context
 		ctx = sentry.SetHubOnContext(ctx, hub)
 		setWatcherTag(scope, eventsWatcherName)
+		setSampleRateOnScope(scope, sampleRate)
 		sentryEvent := handleGeneralEvent(ctx, eventObject, scope)
 		if sentryEvent != nil {
-			hub.CaptureEvent(sentryEvent)
+			route.applyToEvent(sentryEvent)
+			overrides.apply(sentryEvent)
+			applyCustomTags(ctx, scope, sentryEvent, involvedObject)
+			captureEventToDestinations(ctx, hub, scope, sentryEvent, clients, params)
 		}
 	})
 }
 
+// Returns the time of the last occurrence of the event. LastTimestamp only
+// has a precision of one second, EventTime (used when it is not set) has
+// microseconds.
+func getEventTimestamp(eventObject *v1.Event) metav1.Time {
+	if eventObject.LastTimestamp.IsZero() {
+		return metav1.Time(eventObject.EventTime)
+	}
+	return eventObject.LastTimestamp
+}
+
+// Returns the name of the node that the event is related to, if any
+func getEventNodeName(eventObject *v1.Event, involvedObject metav1.Object) string {
+	if eventObject.InvolvedObject.Kind == KindNode {
+		return eventObject.InvolvedObject.Name
+	}
+	if pod, ok := involvedObject.(*v1.Pod); ok && pod.Spec.NodeName != "" {
+		return pod.Spec.NodeName
+	}
+	return eventObject.Source.Host
+}
+
 func watchEventsInNamespace(ctx context.Context, namespace string, watchSince time.Time) (err error) {
 	logger := zerolog.Ctx(ctx)
 
@@ -184,6 +264,7 @@ func watchEventsInNamespace(ctx context.Context, namespace string, watchSince ti
 		}
 		return clientset.CoreV1().Events(namespace).Watch(ctx, opts)
 	}
+	watchFunc = trackWatchFunc(eventsWatcherName, namespace, watchFunc)
 	logger.Debug().Msg("Getting the event watcher")
 	retryWatcher, err := toolsWatch.NewRetryWatcher("1", &cache.ListWatch{WatchFunc: watchFunc})
 	if err != nil {
@@ -196,11 +277,27 @@ func watchEventsInNamespace(ctx context.Context, namespace string, watchSince ti
 	watchSinceWrapped := metav1.Time{Time: watchSince}
 
 	logger.Debug().Msg("Reading from the event channel (events)")
-	for event := range watchCh {
-		handleWatchEvent(ctx, &event, watchSinceWrapped)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watchCh:
+			if !ok {
+				return nil
+			}
+			recordWatcherEvent(eventsWatcherName, namespace)
+			runRecovered(ctx, func() {
+				handleWatchEvent(detachContext(ctx), &event, watchSinceWrapped)
+				// The checkpoint only moves past the events that were handled
+				// (reported or filtered out), not if the handling panicked
+				if eventObject, ok := event.Object.(*v1.Event); ok {
+					if eventTs := getEventTimestamp(eventObject); !eventTs.IsZero() {
+						recordProcessedEventTime(eventTs.Time)
+					}
+				}
+			})
+		}
 	}
-
-	return nil
 }
 
 func watchEventsInNamespaceForever(ctx context.Context, config *rest.Config, namespace string) error {
@@ -230,6 +327,10 @@ func watchEventsInNamespaceForever(ctx context.Context, config *rest.Config, nam
 		logger.Info().Msgf("Watching all available events (no starting timestamp)")
 	} else {
 		watchSince = time.Now()
+		// A new leader resumes from the last event processed by the previous one
+		if resumeFrom := getEventsResumeTime(); !resumeFrom.IsZero() && resumeFrom.Before(watchSince) {
+			watchSince = resumeFrom
+		}
 		logger.Info().Msgf("Watching events starting from: %s", watchSince.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
 	}
 
@@ -240,17 +341,35 @@ func watchEventsInNamespaceForever(ctx context.Context, config *rest.Config, nam
 
 	ctx = setClientsetOnContext(ctx, clientset)
 
+	setWatcherState(eventsWatcherName, namespace, WatcherStateStarting, nil)
+	defer removeWatcherStatus(eventsWatcherName, namespace)
+
 	for {
-		if err := watchEventsInNamespace(ctx, namespace, watchSince); err != nil {
+		err := watchEventsInNamespace(ctx, namespace, watchSince)
+		if err != nil {
 			logger.Error().Msgf("Error while watching events %s: %s", where, err)
 		}
+		setWatcherState(eventsWatcherName, namespace, WatcherStateRestarting, err)
 		watchSince = time.Now()
-		time.Sleep(time.Second * 1)
+		select {
+		case <-ctx.Done():
+			logger.Info().Msgf("Stopped watching events %s", where)
+			return nil
+		case <-time.After(time.Second * 1):
+			countWatchRestart(eventsWatcherName, namespaceTag)
+		}
 	}
 }
 
-func startEventWatchers(ctx context.Context, config *rest.Config, namespaces []string) {
+func startEventWatchers(ctx context.Context, config *rest.Config, namespaces []string, wg *sync.WaitGroup) {
 	for _, namespace := range namespaces {
-		go watchEventsInNamespaceForever(ctx, config, namespace)
+		wg.Add(1)
+		go func(namespace string) {
+			defer reportPanicAndCrash()
+			defer wg.Done()
+			if err := watchEventsInNamespaceForever(ctx, config, namespace); err != nil {
+				zerolog.Ctx(ctx).Error().Msgf("Cannot watch events in namespace %q: %s", namespace, err)
+			}
+		}(namespace)
 	}
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -10,6 +11,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -18,6 +20,9 @@ import (
 
 const podsWatcherName = "pods"
 
+// FIXME: there's no proper controller we can extract here, so inventing a new one
+const podEventSourceComponent = "x-pod-controller"
+
 var cronsMetaData = NewCronsMetaData()
 
 func handlePodTerminationEvent(ctx context.Context, containerStatus *v1.ContainerStatus, pod *v1.Pod, scope *sentry.Scope) *sentry.Event {
@@ -38,8 +43,7 @@ func handlePodTerminationEvent(ctx context.Context, containerStatus *v1.Containe
 	setTagIfNotEmpty(scope, "pod_name", pod.Name)
 	setTagIfNotEmpty(scope, "container_name", containerStatus.Name)
 
-	// FIXME: there's no proper controller we can extract here, so inventing a new one
-	setTagIfNotEmpty(scope, "event_source_component", "x-pod-controller")
+	setTagIfNotEmpty(scope, "event_source_component", podEventSourceComponent)
 
 	if containerStatusJSON, err := prettyJSON(containerStatus); err == nil {
 		scope.SetContext("Container", sentry.Context{
@@ -66,7 +70,8 @@ func buildSentryEventFromPodTerminationEvent(ctx context.Context, pod *v1.Pod, m
 	sentryEvent := &sentry.Event{Message: message, Level: sentry.LevelError}
 	err := runEnhancers(ctx, nil, KindPod, pod, scope, sentryEvent)
 	if err != nil {
-		logger.Err(err)
+		countEnhancerError(KindPod)
+		logger.Error().Msgf("Error while running the enhancers: %v", err)
 	}
 	return sentryEvent
 }
@@ -90,10 +95,25 @@ func handlePodWatchEvent(ctx context.Context, event *watch.Event) {
 
 	logger.Trace().Msgf("Pod Object received: %#v", podObject)
 
+	podParams := &samplingParams{
+		watcher:     podsWatcherName,
+		namespace:   podObject.Namespace,
+		eventSource: podEventSourceComponent,
+	}
+	countReceivedEvent(podParams.watcher, podParams.namespace)
+	defer observeEventProcessing(podParams.watcher, time.Now())
+
+	if !shards.ownsObject(podObject.Namespace, podObject.UID) {
+		logger.Trace().Msgf("Skipping a pod because it belongs to another shard")
+		countFilteredEvent(podParams, FilterShard)
+		return
+	}
+
 	ctx, logger = getLoggerWithTag(ctx, "namespace", podObject.GetNamespace())
 
 	if podObject.DeletionTimestamp != nil {
 		logger.Debug().Msgf("Pod is about to be deleted; ignoring state modifications")
+		countFilteredEvent(podParams, FilterDeletedPod)
 		return
 	}
 
@@ -102,8 +122,6 @@ func handlePodWatchEvent(ctx context.Context, event *watch.Event) {
 		logger.Error().Msgf("Cannot get Sentry hub from context")
 		return
 	}
-	// To avoid concurrency issue
-	hub = hub.Clone()
 
 	containerStatuses := podObject.Status.ContainerStatuses
 	logger.Trace().Msgf("Container statuses: %#v\n", containerStatuses)
@@ -113,19 +131,68 @@ func handlePodWatchEvent(ctx context.Context, event *watch.Event) {
 			// Ignore non-Terminated statuses
 			continue
 		}
+		if state.Terminated.ExitCode == 0 {
+			// Nothing to report
+			continue
+		}
+
+		params := &samplingParams{
+			watcher:     podsWatcherName,
+			namespace:   podObject.Namespace,
+			reason:      state.Terminated.Reason,
+			eventSource: podEventSourceComponent,
+			message:     state.Terminated.Message,
+		}
+
+		// The owners are fetched once for the annotations, the DSN and the tags
+		ctx := withOwnerChain(ctx, podObject)
+		overrides := getReportingOverrides(ctx, podObject, state.Terminated.Reason)
+		if overrides.ignored {
+			logger.Debug().Msgf("Skipping a pod termination event because the pod is ignored via annotations")
+			countFilteredEvent(params, FilterAnnotation)
+			continue
+		}
+
+		muteParams := &muteParams{
+			namespace:    podObject.Namespace,
+			nodeName:     podObject.Spec.NodeName,
+			objectLabels: podObject.Labels,
+		}
+		message := fmt.Sprintf("%s: container %q in pod %q", state.Terminated.Reason, status.Name, podObject.Name)
+		if suppressIfMuted(ctx, muteParams, message) {
+			countFilteredEvent(params, FilterMute)
+			continue
+		}
+
+		sampleRate, keep := sampleEvent(params)
+		if !keep {
+			logger.Debug().Msgf("Skipping a pod termination event because of sampling (sample rate: %v)", sampleRate)
+			countFilteredEvent(params, FilterSampling)
+			continue
+		}
+
+		// A clone per container, to avoid concurrency issues, and so that the
+		// client bound for a container is not used for the next one
+		hub := hub.Clone()
 		hub.WithScope(func(scope *sentry.Scope) {
-			// If DSN annotation provided, we bind a new client with that DSN
-			client, ok := dsnClientMapping.GetClientFromObject(ctx, &podObject.ObjectMeta, hub.Client().Options())
-			if ok {
-				hub.BindClient(client)
-			}
+			// Routing rules are evaluated first, DSN annotations take precedence over them
+			route := findRoutingRule(ctx, podObject.Namespace)
+			route.applyToScope(scope)
+
+			// If DSN annotations are provided, the event is sent to those DSNs
+			clients := getDestinationClients(ctx, hub, route, &podObject.ObjectMeta)
+			hub.BindClient(clients[0])
 
 			// Pass down clone context
 			ctx = sentry.SetHubOnContext(ctx, hub)
 			setWatcherTag(scope, podsWatcherName)
+			setSampleRateOnScope(scope, sampleRate)
 			sentryEvent := handlePodTerminationEvent(ctx, &containerStatuses[i], podObject, scope)
 			if sentryEvent != nil {
-				hub.CaptureEvent(sentryEvent)
+				route.applyToEvent(sentryEvent)
+				overrides.apply(sentryEvent)
+				applyCustomTags(ctx, scope, sentryEvent, podObject)
+				captureEventToDestinations(ctx, hub, scope, sentryEvent, clients, params)
 			}
 		})
 	}
@@ -146,6 +213,7 @@ func watchPodsInNamespace(ctx context.Context, namespace string) (err error) {
 		}
 		return clientset.CoreV1().Pods(namespace).Watch(ctx, opts)
 	}
+	watchFunc = trackWatchFunc(podsWatcherName, namespace, watchFunc)
 	logger.Debug().Msg("Getting the pod watcher")
 	retryWatcher, err := toolsWatch.NewRetryWatcher("1", &cache.ListWatch{WatchFunc: watchFunc})
 	if err != nil {
@@ -156,11 +224,20 @@ func watchPodsInNamespace(ctx context.Context, namespace string) (err error) {
 	defer retryWatcher.Stop()
 
 	logger.Debug().Msg("Reading from the event channel (pods)")
-	for event := range watchCh {
-		handlePodWatchEvent(ctx, &event)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watchCh:
+			if !ok {
+				return nil
+			}
+			recordWatcherEvent(podsWatcherName, namespace)
+			runRecovered(ctx, func() {
+				handlePodWatchEvent(detachContext(ctx), &event)
+			})
+		}
 	}
-
-	return nil
 }
 
 // TODO: dedupe with events
@@ -191,21 +268,57 @@ func watchPodsInNamespaceForever(ctx context.Context, config *rest.Config, names
 
 	ctx = setClientsetOnContext(ctx, clientset)
 
+	if isArgoCronWorkflowsMonitoringEnabled() {
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return err
+		}
+		ctx = setDynamicClientOnContext(ctx, dynamicClient)
+	}
+
+	setWatcherState(podsWatcherName, namespace, WatcherStateStarting, nil)
+	defer removeWatcherStatus(podsWatcherName, namespace)
+
 	// Start the informers for Sentry event capturing
 	// and caching with the indexers
-	go startInformers(ctx, namespace)
+	informersDone := make(chan struct{})
+	go func() {
+		defer reportPanicAndCrash()
+		defer close(informersDone)
+		if err := startInformers(ctx, namespace); err != nil && ctx.Err() == nil {
+			logger.Error().Msgf("Error while running informers %s: %s", where, err)
+			setInformersState(namespace, InformersStateFailed, err)
+		}
+	}()
 
 	for {
-		if err := watchPodsInNamespace(ctx, namespace); err != nil {
+		err := watchPodsInNamespace(ctx, namespace)
+		if err != nil {
 			logger.Error().Msgf("Error while watching pods %s: %s", where, err)
 		}
+		setWatcherState(podsWatcherName, namespace, WatcherStateRestarting, err)
 		// Note: some events might be lost when we're sleeping here
-		time.Sleep(time.Second * 1)
+		select {
+		case <-ctx.Done():
+			<-informersDone
+			removeInformersStatus(namespace)
+			logger.Info().Msgf("Stopped watching pods %s", where)
+			return nil
+		case <-time.After(time.Second * 1):
+			countWatchRestart(podsWatcherName, namespaceTag)
+		}
 	}
 }
 
-func startPodWatchers(ctx context.Context, config *rest.Config, namespaces []string) {
+func startPodWatchers(ctx context.Context, config *rest.Config, namespaces []string, wg *sync.WaitGroup) {
 	for _, namespace := range namespaces {
-		go watchPodsInNamespaceForever(ctx, config, namespace)
+		wg.Add(1)
+		go func(namespace string) {
+			defer reportPanicAndCrash()
+			defer wg.Done()
+			if err := watchPodsInNamespaceForever(ctx, config, namespace); err != nil {
+				zerolog.Ctx(ctx).Error().Msgf("Cannot watch pods in namespace %q: %s", namespace, err)
+			}
+		}(namespace)
 	}
 }